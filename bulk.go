@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"strings"
 	"sync"
 )
@@ -34,43 +35,48 @@ func BulkFolders(user KiteUser, folder_list string) (folders []KiteFolder) {
 }
 
 // Bulk process for handling process call back against multiple users.
-func BulkAction(user_filter KiteUser, process func(user KiteUser)) error {
+// Each user's outcome is checkpointed, so an interrupted run can be resumed with --resume.
+// No new users are started once ctx is cancelled.
+func BulkAction(ctx context.Context, user_filter KiteUser, process func(user KiteUser) error) error {
 	ShowLoader()
 	defer HideLoader()
 	s := KWAdmin
 
 	wg := new(sync.WaitGroup)
 
-	var i int
+	// Users handled during this invocation, so a retried user isn't processed twice.
+	seen := make(map[string]struct{})
 
-	for {
-		var (
-			u   []KiteUser
-			err error
-		)
-		if len(global.user_list) == 0 {
-			u, err = s.GetUsers(100, i)
-			if err != nil {
-				return err
-			}
+	// Users cut short by cancellation are marked failed, so a resume picks them up again.
+	do := func(user KiteUser) {
+		defer progress_user()
+		err := process(user)
+		if err == nil {
+			err = ctx.Err()
+		}
+		checkpoint_user(user.Email, err)
+		UnsetUserCache(user)
+		retire_token(user.Email)
+	}
 
-			count := len(u)
-			if count == 0 {
+	run := func(users []KiteUser) {
+		for _, user := range users {
+			if ctx.Err() != nil {
 				break
 			}
-			i = i + count
-		} else {
-			for _, email := range global.user_list {
-				user_info, err := s.KWUser(email)
-				if err != nil {
-					Err("Unable to process user '%s': %s", email, err.Error())
-					continue
-				}
-				u = append(u, *user_info)
+
+			email := strings.ToLower(user.Email)
+			if _, ok := seen[email]; ok {
+				continue
 			}
-		}
-		for _, user := range u {
+			seen[email] = struct{}{}
+
 			if user.Deleted != user_filter.Deleted || user.Active != user_filter.Active || user.Suspended != user_filter.Suspended || user.Deactivated != user_filter.Deactivated {
+				progress_user()
+				continue
+			}
+
+			if user_state(email) == USER_DONE {
 				continue
 			}
 
@@ -78,18 +84,62 @@ func BulkAction(user_filter KiteUser, process func(user KiteUser)) error {
 				wg.Add(1)
 				go func(user KiteUser) {
 					defer wg.Done()
-					process(user)
-					UnsetUserCache(user)
+					do(user)
 				}(user)
 			} else {
-				process(user)
-				UnsetUserCache(user)
+				do(user)
 			}
 		}
 		wg.Wait()
-		if len(global.user_list) > 0 {
+	}
+
+	// Looks up users by email.
+	lookup := func(emails []string) (users []KiteUser) {
+		for _, email := range emails {
+			user_info, err := s.KWUser(email)
+			if err != nil {
+				Err("Unable to process user '%s': %s", email, err.Error())
+				checkpoint_user(email, err)
+				continue
+			}
+			users = append(users, *user_info)
+		}
+		return
+	}
+
+	done, _ := progress_counts(run_id())
+	progress_start(done)
+
+	if len(global.user_list) > 0 {
+		progress_total(len(global.user_list))
+		run(lookup(global.user_list))
+		return ctx.Err()
+	}
+
+	// Retry users that failed before picking up where the listing left off.
+	if failed := failed_users(); len(failed) > 0 {
+		run(lookup(failed))
+	}
+
+	users := s.UserPager(PAGE_SIZE)
+	users.Offset = resume_offset()
+
+	for {
+		var u []KiteUser
+		more, err := users.Next(&u)
+		if err != nil {
+			return err
+		}
+		if !more {
 			break
 		}
+		progress_total(users.Total)
+		run(u)
+		// A cancelled page may have users that were never started, keep the offset before it.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		checkpoint_offset(users.Offset)
 	}
 	return nil
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Time given to requests in flight to finish after the first interrupt.
+const CANCEL_GRACE = time.Duration(30 * time.Second)
+
+// Contexts of the run.
+// The work context stops new users and folders from being started on the first interrupt,
+// the request context cancels requests in flight on a second interrupt or once the grace period is up.
+var run_ctx struct {
+	mutex      sync.Mutex
+	work       context.Context
+	stop       context.CancelFunc
+	request    context.Context
+	abort      context.CancelFunc
+	grace      *time.Timer
+	cancelled  int32
+	interrupts int32
+}
+
+func init() {
+	reset_cancel()
+}
+
+// Gives the next run fresh contexts, so a cancelled task doesn't cancel the one after it.
+func reset_cancel() {
+	run_ctx.mutex.Lock()
+	defer run_ctx.mutex.Unlock()
+	if run_ctx.grace != nil {
+		run_ctx.grace.Stop()
+	}
+	run_ctx.work, run_ctx.stop = context.WithCancel(context.Background())
+	run_ctx.request, run_ctx.abort = context.WithCancel(context.Background())
+	run_ctx.grace = nil
+	atomic.StoreInt32(&run_ctx.cancelled, 0)
+	atomic.StoreInt32(&run_ctx.interrupts, 0)
+}
+
+// Returns the context for scheduling work, cancelled on the first interrupt.
+func work_context() context.Context {
+	run_ctx.mutex.Lock()
+	defer run_ctx.mutex.Unlock()
+	return run_ctx.work
+}
+
+// Returns the context for API requests, cancelled on a second interrupt or after the grace period.
+func request_context() context.Context {
+	run_ctx.mutex.Lock()
+	defer run_ctx.mutex.Unlock()
+	return run_ctx.request
+}
+
+// Returns true if the run was cancelled.
+func cancelled() bool {
+	return atomic.LoadInt32(&run_ctx.cancelled) == 1
+}
+
+// Stops scheduling new work, requests in flight are given CANCEL_GRACE to finish.
+func cancel_run() {
+	if !atomic.CompareAndSwapInt32(&run_ctx.cancelled, 0, 1) {
+		return
+	}
+	run_ctx.mutex.Lock()
+	defer run_ctx.mutex.Unlock()
+	run_ctx.stop()
+	run_ctx.grace = time.AfterFunc(CANCEL_GRACE, run_ctx.abort)
+}
+
+// Cancels requests in flight.
+func abort_run() {
+	run_ctx.mutex.Lock()
+	defer run_ctx.mutex.Unlock()
+	run_ctx.abort()
+}
+
+// Watches for Ctrl-C, the first stops new work, the second aborts requests in flight.
+func handle_interrupts() {
+	// Take interrupts over from nfo, which would otherwise exit on the first Ctrl-C.
+	signal.Reset(os.Interrupt, syscall.SIGTERM)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		for {
+			<-signals
+			switch atomic.AddInt32(&run_ctx.interrupts, 1) {
+			case 1:
+				Warn("Interrupt received, finishing requests in flight. (Waiting up to %s, press Ctrl-C again to abort.)", CANCEL_GRACE.String())
+				cancel_run()
+			case 2:
+				Warn("Aborting requests in flight.")
+				abort_run()
+			default:
+				Exit(1)
+			}
+		}
+	}()
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/cmcoffee/go-iotimeout"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recorded API exchange.
+type cassette_entry struct {
+	Method         string      `json:"method"`
+	URI            string      `json:"uri"`
+	RequestBody    string      `json:"request_body,omitempty"`
+	Status         int         `json:"status"`
+	ResponseHeader http.Header `json:"response_header,omitempty"`
+	ResponseBody   string      `json:"response_body,omitempty"`
+}
+
+// Cassette of recorded API traffic, used to record a run or replay one without the network.
+type cassette struct {
+	Version      string           `json:"version"`
+	Recorded     string           `json:"recorded"`
+	Server       string           `json:"server"`
+	Interactions []cassette_entry `json:"interactions"`
+	filename     string
+	replay       bool
+	next         http.RoundTripper
+	mutex        sync.Mutex
+	played       map[string]int
+}
+
+// Starts recording API traffic to filename.
+func record_cassette(filename string) *cassette {
+	c := &cassette{
+		Version:  VERSION_STRING,
+		Recorded: time.Now().UTC().Format(time.RFC3339),
+		Server:   global.config.Server,
+		filename: filename,
+	}
+	task_defer(c.save)
+	return c
+}
+
+// Loads a cassette for replay from filename.
+func replay_cassette(filename string) (*cassette, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	c := &cassette{
+		filename: filename,
+		replay:   true,
+		played:   make(map[string]int),
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("Unable to read cassette %s: %s", filename, err.Error())
+	}
+	return c, nil
+}
+
+// Wraps transport for recording, or replaces it entirely when replaying.
+func (c *cassette) transport(next http.RoundTripper) http.RoundTripper {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.next = next
+	return c
+}
+
+// Returns true if uri is a token request, allowing for the configured base path.
+func oauth_request(uri string) bool {
+	if base := global.config.base_path(); base != NONE && strings.HasPrefix(uri, base+"/") {
+		uri = strings.TrimPrefix(uri, base)
+	}
+	return strings.HasPrefix(uri, "/oauth/")
+}
+
+// Key used to match a request against recorded exchanges.
+func cassette_key(method, uri, body string) string {
+	if oauth_request(uri) {
+		return fmt.Sprintf("%s %s", method, uri)
+	}
+	return fmt.Sprintf("%s %s %s", method, uri, body)
+}
+
+// Hides token request secrets.
+func redact_form(body string) string {
+	form, err := url.ParseQuery(body)
+	if err != nil {
+		return body
+	}
+	for _, k := range []string{"client_secret", "code", "refresh_token", "password"} {
+		if _, ok := form[k]; ok {
+			form.Set(k, "[HIDDEN]")
+		}
+	}
+	return form.Encode()
+}
+
+// Hides tokens in a JSON response.
+func redact_json(body []byte) string {
+	var generic map[string]interface{}
+	if err := json.Unmarshal(body, &generic); err != nil || generic == nil {
+		return string(body)
+	}
+	redacted := false
+	for _, k := range []string{"access_token", "refresh_token"} {
+		if _, ok := generic[k]; ok {
+			generic[k] = "[HIDDEN]"
+			redacted = true
+		}
+	}
+	if !redacted {
+		return string(body)
+	}
+	output, _ := json.Marshal(generic)
+	return string(output)
+}
+
+func (c *cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	var req_body []byte
+	if req.Body != nil {
+		var err error
+		req_body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(req_body))
+	}
+
+	uri := req.URL.RequestURI()
+
+	body := string(req_body)
+	if oauth_request(uri) {
+		body = redact_form(body)
+	}
+
+	if c.replay {
+		return c.play(req, cassette_key(req.Method, uri, body))
+	}
+
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp_body, err := ioutil.ReadAll(iotimeout.NewReadCloser(resp.Body, timeout))
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(resp_body))
+
+	header := resp.Header.Clone()
+	header.Del("Set-Cookie")
+	header.Del("Content-Length")
+
+	c.mutex.Lock()
+	c.Interactions = append(c.Interactions, cassette_entry{
+		Method:         req.Method,
+		URI:            uri,
+		RequestBody:    body,
+		Status:         resp.StatusCode,
+		ResponseHeader: header,
+		ResponseBody:   redact_json(resp_body),
+	})
+	c.mutex.Unlock()
+
+	return resp, nil
+}
+
+// Serves the next recorded response for key.
+func (c *cassette) play(req *http.Request, key string) (*http.Response, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var matches []int
+	for i, v := range c.Interactions {
+		if cassette_key(v.Method, v.URI, v.RequestBody) == key {
+			matches = append(matches, i)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("No recorded response in %s for %s %s.", c.filename, req.Method, req.URL.RequestURI())
+	}
+
+	// Play matches in recorded order, repeating the last once exhausted.
+	n := c.played[key]
+	if n >= len(matches) {
+		n = len(matches) - 1
+	}
+	c.played[key] = n + 1
+	entry := c.Interactions[matches[n]]
+
+	header := entry.ResponseHeader
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.Status, http.StatusText(entry.Status)),
+		StatusCode:    entry.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header.Clone(),
+		Body:          ioutil.NopCloser(strings.NewReader(entry.ResponseBody)),
+		ContentLength: int64(len(entry.ResponseBody)),
+		Request:       req,
+	}, nil
+}
+
+// Writes recorded traffic out to the cassette file.
+func (c *cassette) save() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	output, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		Err("Unable to save recording: %s", err.Error())
+		return
+	}
+	if err := ioutil.WriteFile(c.filename, output, 0600); err != nil {
+		Err("Unable to save recording: %s", err.Error())
+		return
+	}
+	Log("Recorded %d API exchanges to %s.", len(c.Interactions), c.filename)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// User states within a run.
+const (
+	USER_DONE   = "done"
+	USER_FAILED = "failed"
+)
+
+// Table holding the state of each user processed by run id.
+func progress_table(run_id string) string {
+	return fmt.Sprintf("progress_%s", run_id)
+}
+
+// Records whether user finished, err being the outcome of processing the user.
+func checkpoint_user(email string, err error) {
+	id := run_id()
+	if id == NONE {
+		return
+	}
+	state := USER_DONE
+	if err != nil {
+		state = USER_FAILED
+	}
+	global.db.Set(progress_table(id), strings.ToLower(email), state)
+}
+
+// Returns the state of user in the current run.
+func user_state(email string) (state string) {
+	id := run_id()
+	if id == NONE {
+		return NONE
+	}
+	global.db.Get(progress_table(id), strings.ToLower(email), &state)
+	return
+}
+
+// Returns users that failed in the current run.
+func failed_users() (users []string) {
+	id := run_id()
+	if id == NONE {
+		return nil
+	}
+	for _, email := range global.db.ListKeys(progress_table(id)) {
+		if user_state(email) == USER_FAILED {
+			users = append(users, email)
+		}
+	}
+	return
+}
+
+// Saves the offset of the user listing, all users before it have been processed.
+func checkpoint_offset(offset int) {
+	current_run.mutex.Lock()
+	defer current_run.mutex.Unlock()
+
+	r := current_run.record
+	if r == nil {
+		return
+	}
+	r.Offset = offset
+	global.db.Set(runs_table, r.ID, r)
+}
+
+// Returns the saved offset of the user listing for the current run.
+func resume_offset() int {
+	current_run.mutex.Lock()
+	defer current_run.mutex.Unlock()
+	if current_run.record == nil {
+		return 0
+	}
+	return current_run.record.Offset
+}
+
+// Loads run id for resuming, the task must match the run being resumed.
+func load_resume(task string, id string) (record run_record, err error) {
+	record, found := get_run(id)
+	if !found {
+		return record, fmt.Errorf("No run found with id '%s'.", id)
+	}
+	if record.Task != task {
+		return record, fmt.Errorf("Run '%s' was a '%s' run, resume it with: %s %s --resume %s", id, record.Task, APPNAME, record.Task, id)
+	}
+	if err := record.check_profile(); err != nil {
+		return record, err
+	}
+	switch record.Status {
+	case RUN_COMPLETED:
+		if _, failed := progress_counts(id); failed == 0 {
+			return record, fmt.Errorf("Run '%s' already completed, there is nothing to resume.", id)
+		}
+	case RUN_ROLLED_BACK:
+		return record, fmt.Errorf("Run '%s' was rolled back and cannot be resumed.", id)
+	}
+	return record, nil
+}
+
+// Returns the count of users done and failed in run id.
+func progress_counts(id string) (done, failed int) {
+	table := progress_table(id)
+	for _, email := range global.db.ListKeys(table) {
+		var state string
+		global.db.Get(table, email, &state)
+		switch state {
+		case USER_DONE:
+			done++
+		case USER_FAILED:
+			failed++
+		}
+	}
+	return
+}
@@ -27,21 +27,7 @@ func init() {
 	nfo.HideTS()
 	errchk(nfo.File(nfo.STD, fmt.Sprintf("%s.log", APPNAME), 0, 0))
 	global.start_time = time.Now()
-	go func() {
-		for {
-			runtime_str := fmt.Sprintf("%s Running (%s)", APPNAME, time.Now().Sub(global.start_time).Round(time.Second).String())
-			for _, str := range loader {
-				if atomic.LoadInt32(&global.show_loader) == 1 {
-					if global.snoop {
-						goto Exit
-					}
-					nfo.Flash("%s %s %s", str, runtime_str, str)
-				}
-				time.Sleep(125 * time.Millisecond)
-			}
-		}
-	Exit:
-	}()
+	go run_loader()
 }
 
 // Displays loader. "[>>>] Working, Please wait."
@@ -64,25 +50,18 @@ const (
 	SLASH = string(os.PathSeparator)
 )
 
-// Loggers
+// Loggers, Log, Warn and Err are found in log.go.
 var (
-	Log    = nfo.Log
 	Fatal  = nfo.Fatal
 	Notice = nfo.Notice
 	Flash  = nfo.Flash
 	Stdout = nfo.Stdout
 	Stderr = nfo.Stderr
-	Warn   = nfo.Warn
 	Defer  = nfo.Defer
 	Printf = nfo.Stdout
 	Exit   = nfo.Exit
 )
 
-func Err(input ...interface{}) {
-	global.errors.Add(1)
-	nfo.Err(input...)
-}
-
 var path = filepath.Clean
 
 type database struct {
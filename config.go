@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Prefix of environment variables that override configuration, ie.. KITETOOL_SERVER.
+const config_env_prefix = "KITETOOL_"
+
+// Configuration settings that are hidden when shown.
+var config_secrets = map[string]struct{}{
+	"client_secret":  {},
+	"signature":      {},
+	"proxy_password": {},
+}
+
+// Returns the configuration keys, as named by their json tags.
+func config_keys() (keys []string) {
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if key := config_key(t.Field(i)); key != NONE {
+			keys = append(keys, key)
+		}
+	}
+	return
+}
+
+// Returns the configuration key of field.
+func config_key(field reflect.StructField) string {
+	key := strings.Split(field.Tag.Get("json"), ",")[0]
+	if key == "-" {
+		return NONE
+	}
+	return key
+}
+
+// Returns the field of cfg for key.
+func (c *Config) field(key string) (reflect.Value, error) {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	key = strings.ToLower(strings.TrimSpace(key))
+	for i := 0; i < t.NumField(); i++ {
+		if config_key(t.Field(i)) == key {
+			return v.Field(i), nil
+		}
+	}
+	return v, fmt.Errorf("Unknown configuration setting '%s', settings are: %s", key, strings.Join(config_keys(), ", "))
+}
+
+// Sets key to value, value is parsed according to the setting's type.
+func (c *Config) set(key, value string) error {
+	f, err := c.field(key)
+	if err != nil {
+		return err
+	}
+	value = strings.TrimSpace(value)
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s should be true or false, got '%s'.", key, value)
+		}
+		f.SetBool(b)
+	case reflect.Int:
+		if value == NONE {
+			value = "0"
+		}
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s should be a number, got '%s'.", key, value)
+		}
+		f.SetInt(int64(i))
+	default:
+		return fmt.Errorf("%s cannot be set.", key)
+	}
+	return nil
+}
+
+// Returns the value of key as a string.
+func (c *Config) get(key string) (string, error) {
+	f, err := c.field(key)
+	if err != nil {
+		return NONE, err
+	}
+	if f.Kind() == reflect.String && f.String() == no_proxy {
+		return NONE, nil
+	}
+	return fmt.Sprintf("%v", f.Interface()), nil
+}
+
+// Reads configuration settings from a json or yaml file, settings not in the file are left as they are.
+func (c *Config) load_file(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	settings := make(map[string]interface{})
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &settings)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&settings)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to read %s: %s", filename, err.Error())
+	}
+
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		var value string
+		if settings[k] != nil {
+			value = fmt.Sprintf("%v", settings[k])
+		}
+		if err := c.set(k, value); err != nil {
+			return fmt.Errorf("%s: %s", filename, err.Error())
+		}
+	}
+	return nil
+}
+
+// Applies KITETOOL_* environment variable overrides, ie.. KITETOOL_CLIENT_SECRET.
+func (c *Config) load_env() error {
+	for _, k := range config_keys() {
+		if value, ok := os.LookupEnv(config_env_prefix + strings.ToUpper(k)); ok {
+			if err := c.set(k, value); err != nil {
+				return fmt.Errorf("%s%s: %s", config_env_prefix, strings.ToUpper(k), err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// Applies the --config-file, KITETOOL_* and --resolve overrides, none of which are saved.
+func (c *Config) apply_overrides() error {
+	if global.config_file != NONE {
+		if err := c.load_file(global.config_file); err != nil {
+			return err
+		}
+	}
+	if err := c.load_env(); err != nil {
+		return err
+	}
+	if global.resolve != NONE {
+		c.Resolve = global.resolve
+	}
+	return c.check_resolve()
+}
+
+// Saves configuration to the selected profile.
+func save_config(cfg *Config) {
+	global.db.CryptSet(profiles_table, global.profile, cfg)
+}
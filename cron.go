@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parsed cron expression, each field is a bit set of the values it matches.
+type cron_spec struct {
+	expr     string
+	minute   uint64
+	hour     uint64
+	dom      uint64
+	month    uint64
+	dow      uint64
+	dom_star bool
+	dow_star bool
+}
+
+// Shorthands for common schedules.
+var cron_shorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cron_months = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var cron_days = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Parses a five field cron expression: minute hour day-of-month month day-of-week.
+// Fields accept *, lists, ranges and steps, ie.. "30 2 * * 1-5" or "*/15 * * * *".
+func parse_cron(expr string) (*cron_spec, error) {
+	expr = strings.TrimSpace(expr)
+	fields := strings.Fields(expr)
+	if len(fields) == 1 {
+		if v, ok := cron_shorthands[strings.ToLower(fields[0])]; ok {
+			fields = strings.Fields(v)
+		}
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Invalid schedule '%s', expected 5 fields: minute hour day-of-month month day-of-week.", expr)
+	}
+
+	spec := &cron_spec{expr: expr}
+
+	var err error
+	if spec.minute, err = cron_field(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("Invalid minute in schedule '%s': %s", expr, err.Error())
+	}
+	if spec.hour, err = cron_field(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("Invalid hour in schedule '%s': %s", expr, err.Error())
+	}
+	if spec.dom, err = cron_field(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("Invalid day of month in schedule '%s': %s", expr, err.Error())
+	}
+	if spec.month, err = cron_field(fields[3], 1, 12, cron_months); err != nil {
+		return nil, fmt.Errorf("Invalid month in schedule '%s': %s", expr, err.Error())
+	}
+	if spec.dow, err = cron_field(fields[4], 0, 7, cron_days); err != nil {
+		return nil, fmt.Errorf("Invalid day of week in schedule '%s': %s", expr, err.Error())
+	}
+	// Sunday may be written as 0 or 7.
+	if spec.dow&(1<<7) != 0 {
+		spec.dow |= 1
+	}
+	spec.dom_star = strings.HasPrefix(fields[2], "*")
+	spec.dow_star = strings.HasPrefix(fields[4], "*")
+
+	return spec, nil
+}
+
+// Parses a cron field value, names are matched against names when given.
+func cron_value(input string, min int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.ToLower(input) == name {
+			return i + min, nil
+		}
+	}
+	return strconv.Atoi(input)
+}
+
+// Parses a cron field into a bit set of the values it matches.
+func cron_field(field string, min, max int, names []string) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i > -1 {
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step '%s'", part)
+			}
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			split := strings.SplitN(part, "-", 2)
+			if lo, err = cron_value(split[0], min, names); err != nil {
+				return 0, fmt.Errorf("bad range '%s'", part)
+			}
+			if hi, err = cron_value(split[1], min, names); err != nil {
+				return 0, fmt.Errorf("bad range '%s'", part)
+			}
+		default:
+			if lo, err = cron_value(part, min, names); err != nil {
+				return 0, fmt.Errorf("bad value '%s'", part)
+			}
+			hi = lo
+			// A step on a single value runs from that value to the end of the range, ie.. 5/15.
+			if step > 1 {
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("'%s' is outside of %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Returns true if the day of t matches, when both day fields are restricted either may match.
+func (c *cron_spec) day_matches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.dom_star || c.dow_star {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Returns the first time after t the schedule fires, or a zero time if it never does.
+// When clocks go forward past a scheduled hour, the schedule fires at the first minute after the change.
+// When clocks go back, an hour that is repeated only fires again for schedules that run every hour.
+func (c *cron_spec) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = cron_advance(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if !c.day_matches(t) {
+			t = cron_advance(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		prev := t
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			// Counted in minutes, as the top of the next hour may not exist when clocks go forward.
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		} else if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+		} else if c.hour != cron_every_hour && cron_repeated(t) {
+			t = t.Add(time.Minute)
+		} else {
+			return t
+		}
+		if c.skipped_hour(prev, t) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Hours field of a schedule that runs every hour.
+const cron_every_hour = 1<<24 - 1
+
+// Returns to, or an hour after from when to isn't later, as happens when midnight doesn't exist.
+func cron_advance(from, to time.Time) time.Time {
+	if to.After(from) {
+		return to
+	}
+	return from.Add(time.Hour)
+}
+
+// Returns true if clocks went forward between from and to, skipping over an hour of the schedule.
+func (c *cron_spec) skipped_hour(from, to time.Time) bool {
+	if from.YearDay() != to.YearDay() {
+		return false
+	}
+	for h := from.Hour() + 1; h < to.Hour(); h++ {
+		if c.hour&(1<<uint(h)) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the wall clock time of t already happened, as it does in the hour repeated when clocks go back.
+func cron_repeated(t time.Time) bool {
+	_, offset := t.Zone()
+	_, earlier := t.Add(-24 * time.Hour).Zone()
+	if earlier <= offset {
+		return false
+	}
+	const wall_clock = "2006-01-02 15:04"
+	return t.Add(-time.Duration(earlier-offset)*time.Second).Format(wall_clock) == t.Format(wall_clock)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr  string
+		valid bool
+	}{
+		{"* * * * *", true},
+		{"*/15 * * * *", true},
+		{"5/15 * * * *", true},
+		{"30 2 * * 1-5", true},
+		{"0 9 * jan-mar mon,wed,fri", true},
+		{"0 0 * * 7", true},
+		{"@daily", true},
+		{"@HOURLY", true},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"*/0 * * * *", false},
+		{"5-1 * * * *", false},
+		{"x * * * *", false},
+		{"@sometimes", false},
+	}
+	for _, tt := range tests {
+		_, err := parse_cron(tt.expr)
+		if (err == nil) != tt.valid {
+			t.Errorf("parse_cron(%q) error = %v, want valid %v", tt.expr, err, tt.valid)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	utc := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		expr string
+		from string
+		want []string
+	}{
+		{"* * * * *", "2026-01-01 10:00", []string{"2026-01-01 10:01", "2026-01-01 10:02"}},
+		{"*/15 * * * *", "2026-01-01 10:07", []string{"2026-01-01 10:15", "2026-01-01 10:30", "2026-01-01 10:45", "2026-01-01 11:00"}},
+		{"5/15 * * * *", "2026-01-01 10:00", []string{"2026-01-01 10:05", "2026-01-01 10:20", "2026-01-01 10:35", "2026-01-01 10:50", "2026-01-01 11:05"}},
+		{"30 2 * * *", "2026-01-01 02:30", []string{"2026-01-02 02:30"}},
+		{"0 0 1 * *", "2026-01-15 12:00", []string{"2026-02-01 00:00", "2026-03-01 00:00"}},
+		{"0 9 * * mon-fri", "2026-01-02 10:00", []string{"2026-01-05 09:00", "2026-01-06 09:00"}},
+		{"0 0 * * 7", "2026-01-01 00:00", []string{"2026-01-04 00:00", "2026-01-11 00:00"}},
+		// Both day fields restricted, either may match: the 13th, or any Friday.
+		{"0 0 13 * fri", "2026-01-01 00:00", []string{"2026-01-02 00:00", "2026-01-09 00:00", "2026-01-13 00:00", "2026-01-16 00:00"}},
+		// Only one day field restricted, both must match.
+		{"0 0 13 * *", "2026-01-01 00:00", []string{"2026-01-13 00:00", "2026-02-13 00:00"}},
+		{"0 0 29 2 *", "2026-01-01 00:00", []string{"2028-02-29 00:00"}},
+		{"@weekly", "2026-01-01 00:00", []string{"2026-01-04 00:00"}},
+		{"0 0 30 2 *", "2026-01-01 00:00", []string{""}},
+	}
+	for _, tt := range tests {
+		spec, err := parse_cron(tt.expr)
+		if err != nil {
+			t.Fatalf("parse_cron(%q): %v", tt.expr, err)
+		}
+		from := utc(tt.from)
+		for _, want := range tt.want {
+			got := spec.next(from)
+			if want == "" {
+				if !got.IsZero() {
+					t.Errorf("%q next(%v) = %v, want never", tt.expr, from, got)
+				}
+				break
+			}
+			if !got.Equal(utc(want)) {
+				t.Errorf("%q next(%v) = %v, want %s", tt.expr, from, got, want)
+				break
+			}
+			from = got
+		}
+	}
+}
+
+func TestCronNextDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	local := func(s string, zone string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04 MST", s+" "+zone, ny)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	// Clocks go forward from 02:00 EST to 03:00 EDT on 2026-03-08, and back from 02:00 EDT to 01:00 EST on 2026-11-01.
+	tests := []struct {
+		expr string
+		from time.Time
+		want []time.Time
+	}{
+		// A scheduled hour that is skipped fires at the first minute after the change, once.
+		{"30 2 * * *", local("2026-03-07 12:00", "EST"), []time.Time{local("2026-03-08 03:00", "EDT"), local("2026-03-09 02:30", "EDT")}},
+		{"0 * * * *", local("2026-03-08 00:30", "EST"), []time.Time{local("2026-03-08 01:00", "EST"), local("2026-03-08 03:00", "EDT"), local("2026-03-08 04:00", "EDT")}},
+		// A repeated hour fires once for schedules with a set hour.
+		{"30 1 * * *", local("2026-10-31 12:00", "EDT"), []time.Time{local("2026-11-01 01:30", "EDT"), local("2026-11-02 01:30", "EST")}},
+		// And in both for schedules that run every hour.
+		{"*/30 * * * *", local("2026-11-01 00:50", "EDT"), []time.Time{local("2026-11-01 01:00", "EDT"), local("2026-11-01 01:30", "EDT"), local("2026-11-01 01:00", "EST"), local("2026-11-01 01:30", "EST"), local("2026-11-01 02:00", "EST")}},
+	}
+	for _, tt := range tests {
+		spec, err := parse_cron(tt.expr)
+		if err != nil {
+			t.Fatalf("parse_cron(%q): %v", tt.expr, err)
+		}
+		from := tt.from
+		for _, want := range tt.want {
+			got := spec.next(from)
+			if !got.Equal(want) {
+				t.Errorf("%q next(%v) = %v, want %v", tt.expr, from, got, want)
+				break
+			}
+			from = got
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Change that would have been sent to kiteworks during a dry run.
+type planned_change struct {
+	User   string   `json:"user"`
+	Method string   `json:"method"`
+	Path   string   `json:"path"`
+	Params []string `json:"params,omitempty"`
+}
+
+var dry_run struct {
+	mutex   sync.Mutex
+	changes []planned_change
+}
+
+// Records the change api_req would make, returning a synthetic success.
+func (s KWSession) plan_change(api_req APIRequest) error {
+	change := planned_change{
+		User:   string(s),
+		Method: strings.ToUpper(api_req.Method),
+		Path:   api_req.Path,
+	}
+
+	for _, in := range api_req.Params {
+		switch i := in.(type) {
+		case PostForm:
+			p := make(url.Values)
+			for k, v := range i {
+				p.Add(k, sprinter(v))
+			}
+			change.Params = append(change.Params, fmt.Sprintf("form: %s", p.Encode()))
+		case PostJSON:
+			j, err := json.Marshal(i)
+			if err != nil {
+				return err
+			}
+			change.Params = append(change.Params, fmt.Sprintf("json: %s", string(j)))
+		case Query:
+			var keys []string
+			for k := range i {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				change.Params = append(change.Params, fmt.Sprintf("query: %s=%s", k, sprinter(i[k])))
+			}
+		default:
+			return fmt.Errorf("Unknown request exception.")
+		}
+	}
+
+	dry_run.mutex.Lock()
+	dry_run.changes = append(dry_run.changes, change)
+	dry_run.mutex.Unlock()
+
+	return nil
+}
+
+// Prints the planned changes of the dry run and saves them to a file.
+func dry_run_report() {
+	dry_run.mutex.Lock()
+	defer dry_run.mutex.Unlock()
+
+	Log("\n")
+	Log("    -- Dry Run: %d planned changes --", len(dry_run.changes))
+	for _, c := range dry_run.changes {
+		Log("[%s]: %s %s %s", c.User, c.Method, c.Path, strings.Join(c.Params, " "))
+	}
+
+	if len(dry_run.changes) == 0 {
+		return
+	}
+
+	filename := fmt.Sprintf("%s-dry-run-%s.json", APPNAME, global.start_time.Format("20060102-150405"))
+
+	output, err := json.MarshalIndent(dry_run.changes, "", "  ")
+	if err != nil {
+		Err("Unable to save planned changes: %s", err.Error())
+		return
+	}
+	if err := ioutil.WriteFile(filename, output, 0600); err != nil {
+		Err("Unable to save planned changes: %s", err.Error())
+		return
+	}
+	Log("Planned changes saved to %s.", filename)
+}
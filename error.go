@@ -1,12 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"github.com/cmcoffee/go-nfo"
+	"net/http"
 	"strings"
+	"time"
 )
 
+// Flag for a class of kiteworks errors, flags can be matched with errors.Is.
+type ErrorFlag int64
+
 const (
-	ERR_AUTH_UNAUTHORIZED = 1 << iota
+	ERR_AUTH_UNAUTHORIZED ErrorFlag = 1 << iota
 	ERR_AUTH_PROFILE_CHANGED
 	ERR_ACCESS_USER
 	ERR_INVALID_GRANT
@@ -20,54 +27,129 @@ const (
 	ERR_ENTITY_ROLE_IS_ASSIGNED
 	UNAVAILABLE
 	SERVICE_UNAVAILABLE
+	ERR_UNKNOWN
 )
 
 const (
-	TOKEN_ERR = ERR_AUTH_PROFILE_CHANGED | ERR_INVALID_GRANT | ERR_AUTH_UNAUTHORIZED
+	TOKEN_ERR    = ERR_AUTH_PROFILE_CHANGED | ERR_INVALID_GRANT | ERR_AUTH_UNAUTHORIZED
+	THROTTLE_ERR = UNAVAILABLE | SERVICE_UNAVAILABLE
 )
 
+// Names of error flags.
+var error_flag_names = []struct {
+	flag ErrorFlag
+	name string
+}{
+	{ERR_AUTH_UNAUTHORIZED, "ERR_AUTH_UNAUTHORIZED"},
+	{ERR_AUTH_PROFILE_CHANGED, "ERR_AUTH_PROFILE_CHANGED"},
+	{ERR_ACCESS_USER, "ERR_ACCESS_USER"},
+	{ERR_INVALID_GRANT, "ERR_INVALID_GRANT"},
+	{ERR_ENTITY_DELETED_PERMANENTLY, "ERR_ENTITY_DELETED_PERMANENTLY"},
+	{ERR_ENTITY_NOT_FOUND, "ERR_ENTITY_NOT_FOUND"},
+	{ERR_ENTITY_DELETED, "ERR_ENTITY_DELETED"},
+	{ERR_ENTITY_PARENT_FOLDER_DELETED, "ERR_ENTITY_PARENT_FOLDER_DELETED"},
+	{ERR_REQUEST_METHOD_NOT_ALLOWED, "ERR_REQUEST_METHOD_NOT_ALLOWED"},
+	{ERR_INTERNAL_SERVER_ERROR, "ERR_INTERNAL_SERVER_ERROR"},
+	{ERR_ENTITY_EXISTS, "ERR_ENTITY_EXISTS"},
+	{ERR_ENTITY_ROLE_IS_ASSIGNED, "ERR_ENTITY_ROLE_IS_ASSIGNED"},
+	{UNAVAILABLE, "UNAVAILABLE"},
+	{SERVICE_UNAVAILABLE, "SERVICE_UNAVAILABLE"},
+	{ERR_UNKNOWN, "ERR_UNKNOWN"},
+}
+
+// Maps kiteworks error codes to flags, codes are matched in upper case.
+// New codes only need an entry here.
+var kw_error_codes = map[string]ErrorFlag{
+	"ERR_ENTITY_ROLE_IS_ASSIGNED":      ERR_ENTITY_ROLE_IS_ASSIGNED,
+	"ERR_ENTITY_EXISTS":                ERR_ENTITY_EXISTS,
+	"ERR_AUTH_UNAUTHORIZED":            ERR_AUTH_UNAUTHORIZED,
+	"UNAUTHORIZED_CLIENT":              ERR_AUTH_UNAUTHORIZED,
+	"ERR_AUTH_PROFILE_CHANGED":         ERR_AUTH_PROFILE_CHANGED,
+	"ERR_ACCESS_USER":                  ERR_ACCESS_USER,
+	"INVALID_GRANT":                    ERR_INVALID_GRANT,
+	"ERR_ENTITY_DELETED_PERMANENTLY":   ERR_ENTITY_DELETED_PERMANENTLY,
+	"ERR_ENTITY_DELETED":               ERR_ENTITY_DELETED,
+	"ERR_ENTITY_NOT_FOUND":             ERR_ENTITY_NOT_FOUND,
+	"ERR_ENTITY_PARENT_FOLDER_DELETED": ERR_ENTITY_PARENT_FOLDER_DELETED,
+	"ERR_REQUEST_METHOD_NOT_ALLOWED":   ERR_REQUEST_METHOD_NOT_ALLOWED,
+	"UNAVAILABLE":                      UNAVAILABLE,
+	"SERVICE_UNAVAILABLE":              SERVICE_UNAVAILABLE,
+}
+
+// Maps prefixes of kiteworks error codes to flags, for codes not found in kw_error_codes.
+var kw_error_prefixes = []struct {
+	prefix string
+	flag   ErrorFlag
+}{
+	{"ERR_INTERNAL_", ERR_INTERNAL_SERVER_ERROR},
+}
+
+// Returns the flag for a kiteworks error code, ERR_UNKNOWN when the code isn't known.
+func error_flag(code string) ErrorFlag {
+	code = strings.ToUpper(code)
+	if flag, ok := kw_error_codes[code]; ok {
+		return flag
+	}
+	for _, v := range kw_error_prefixes {
+		if strings.HasPrefix(code, v.prefix) {
+			return v.flag
+		}
+	}
+	return ERR_UNKNOWN
+}
+
+// Returns the names of the flags set.
+func (f ErrorFlag) Error() string {
+	var names []string
+	for _, v := range error_flag_names {
+		if f&v.flag != 0 {
+			names = append(names, v.name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("ErrorFlag(%d)", int64(f))
+	}
+	return strings.Join(names, "|")
+}
+
 type APIError struct {
-	flag    int64
-	message []string
+	Status      int      // HTTP status code of the response.
+	Method      string   // Method of the request.
+	Path        string   // Path of the request.
+	User        string   // Account the request was made as.
+	Codes       []string // kiteworks error codes, as sent.
+	flag        ErrorFlag
+	message     []string
+	retry_after time.Duration
 }
 
 // Add a kiteworks error to APIError
 func (e *APIError) AddKWError(code, message string) {
-	code = strings.ToUpper(code)
+	flag := error_flag(code)
+	if flag == ERR_UNKNOWN {
+		nfo.Debug("Unrecognized kiteworks error code: %s", code)
+	}
+	e.flag |= flag
+	e.message = append(e.message, fmt.Sprintf("%s. (%s)", message, strings.ToUpper(code)))
+	e.Codes = append(e.Codes, code)
+}
 
-	switch code {
-	case "ERR_ENTITY_ROLE_IS_ASSIGNED":
-		e.flag |= ERR_ENTITY_ROLE_IS_ASSIGNED
-	case "ERR_ENTITY_EXISTS":
-		e.flag |= ERR_ENTITY_EXISTS
-	case "ERR_AUTH_UNAUTHORIZED":
-		e.flag |= ERR_AUTH_UNAUTHORIZED
-	case "unauthorized_client":
-		e.flag |= ERR_AUTH_UNAUTHORIZED
-	case "ERR_AUTH_PROFILE_CHANGED":
-		e.flag |= ERR_AUTH_PROFILE_CHANGED
-	case "ERR_ACCESS_USER":
-		e.flag |= ERR_ACCESS_USER
-	case "invalid_grant":
-		e.flag |= ERR_INVALID_GRANT
-	case "ERR_ENTITY_DELETED_PERMANENTLY":
-		e.flag |= ERR_ENTITY_DELETED_PERMANENTLY
-	case "ERR_ENTITY_DELETED":
-		e.flag |= ERR_ENTITY_DELETED
-	case "ERR_ENTITY_NOT_FOUND":
-		e.flag |= ERR_ENTITY_NOT_FOUND
-	case "ERR_ENTITY_PARENT_FOLDER_DELETED":
-		e.flag |= ERR_ENTITY_PARENT_FOLDER_DELETED
-	case "ERR_REQUEST_METHOD_NOT_ALLOWED":
-		e.flag |= ERR_REQUEST_METHOD_NOT_ALLOWED
-	case "UNAVAILABLE":
-		e.flag |= UNAVAILABLE
-	default:
-		if strings.Contains(code, "ERR_INTERNAL_") {
-			e.flag |= ERR_INTERNAL_SERVER_ERROR
-		}
+// Returns true if target is an ErrorFlag set on the error, for errors.Is.
+func (e *APIError) Is(target error) bool {
+	if f, ok := target.(ErrorFlag); ok {
+		return e.flag&f != 0
 	}
-	e.message = append(e.message, fmt.Sprintf("%s. (%s)", message, code))
+	return false
+}
+
+// Returns the flags set on the error.
+func (e *APIError) Flags() ErrorFlag {
+	return e.flag
+}
+
+// Returns the endpoint of the failed request, ie.. GET /rest/users.
+func (e *APIError) Endpoint() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s", e.Method, e.Path))
 }
 
 // Returns Error String.
@@ -85,23 +167,14 @@ func (e APIError) Error() string {
 }
 
 // Check for specific error code.
-func RestError(err error, input int64) bool {
-	if e, ok := err.(*APIError); !ok {
-		return false
-	} else {
-		if e.flag&input != 0 {
-			return true
-		}
-	}
-	return false
+func RestError(err error, input ErrorFlag) bool {
+	return errors.Is(err, input)
 }
 
 // Return true if error was generated by REST call.
 func IsRestError(err error) bool {
-	if _, ok := err.(*APIError); ok {
-		return true
-	}
-	return false
+	var e *APIError
+	return errors.As(err, &e)
 }
 
 // Create a new REST error.
@@ -110,3 +183,23 @@ func NewRestError() *APIError {
 	e.message = make([]string, 0)
 	return e
 }
+
+// Create a new REST error for resp, recording the status and request.
+func new_resp_error(resp *http.Response) *APIError {
+	e := NewRestError()
+	e.Status = resp.StatusCode
+	if resp.Request != nil {
+		e.Method = resp.Request.Method
+		e.Path = resp.Request.URL.Path
+	}
+	return e
+}
+
+// Returns the kiteworks error codes of err, comma separated.
+func error_code(err error) string {
+	var e *APIError
+	if errors.As(err, &e) {
+		return strings.ToUpper(strings.Join(e.Codes, ","))
+	}
+	return NONE
+}
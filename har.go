@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/cmcoffee/go-iotimeout"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Headers hidden in HAR archives.
+var har_hidden_headers = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Query parameters hidden in HAR archives.
+var har_hidden_params = []string{"code", "client_secret", "access_token", "refresh_token", "password"}
+
+type har_creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type har_nv struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type har_entry struct {
+	StartedDateTime string       `json:"startedDateTime"`
+	Time            float64      `json:"time"`
+	Request         har_request  `json:"request"`
+	Response        har_response `json:"response"`
+	Cache           struct{}     `json:"cache"`
+	Timings         har_timings  `json:"timings"`
+	ServerIPAddress string       `json:"serverIPAddress,omitempty"`
+	Comment         string       `json:"comment,omitempty"`
+}
+
+type har_request struct {
+	Method      string        `json:"method"`
+	URL         string        `json:"url"`
+	HTTPVersion string        `json:"httpVersion"`
+	Cookies     []har_nv      `json:"cookies"`
+	Headers     []har_nv      `json:"headers"`
+	QueryString []har_nv      `json:"queryString"`
+	PostData    *har_postdata `json:"postData,omitempty"`
+	HeadersSize int           `json:"headersSize"`
+	BodySize    int           `json:"bodySize"`
+}
+
+type har_postdata struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type har_response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []har_nv    `json:"cookies"`
+	Headers     []har_nv    `json:"headers"`
+	Content     har_content `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type har_content struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Timings in milliseconds, -1 when a phase did not apply.
+type har_timings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// Records API traffic to a HAR 1.2 archive, entries are written out as requests complete.
+type har_recorder struct {
+	filename string
+	mutex    sync.Mutex
+	file     *os.File
+	entries  int
+	err      error
+}
+
+// Starts recording API traffic to filename as a HAR archive.
+func record_har(filename string) (*har_recorder, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create HAR archive: %s", err.Error())
+	}
+	creator, _ := json.Marshal(har_creator{Name: APPNAME, Version: VERSION_STRING})
+	if _, err := fmt.Fprintf(f, "{\n  \"log\": {\n    \"version\": \"1.2\",\n    \"creator\": %s,\n    \"entries\": [", creator); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Unable to write HAR archive: %s", err.Error())
+	}
+	h := &har_recorder{filename: filename, file: f}
+	task_defer(h.save)
+	return h, nil
+}
+
+// Appends entry to the HAR file.
+func (h *har_recorder) add(entry har_entry) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(&entry, "      ", "  ")
+	if err == nil {
+		sep := ","
+		if h.entries == 0 {
+			sep = NONE
+		}
+		_, err = fmt.Fprintf(h.file, "%s\n      %s", sep, data)
+	}
+	if err != nil {
+		h.err = err
+		Err("Unable to write HAR archive, no further requests will be recorded: %s", err.Error())
+		return
+	}
+	h.entries++
+}
+
+// Wraps next to record its traffic.
+func (h *har_recorder) transport(next http.RoundTripper) http.RoundTripper {
+	return &har_transport{recorder: h, next: next}
+}
+
+type har_transport struct {
+	recorder *har_recorder
+	next     http.RoundTripper
+}
+
+// Moments of a request, as reported by httptrace.
+type har_trace struct {
+	mutex         sync.Mutex
+	get_conn      time.Time
+	got_conn      time.Time
+	dns_start     time.Time
+	dns_done      time.Time
+	connect_start time.Time
+	connect_done  time.Time
+	tls_start     time.Time
+	tls_done      time.Time
+	wrote_request time.Time
+	first_byte    time.Time
+	remote_addr   string
+}
+
+// Returns milliseconds between start and end, -1 if either didn't happen.
+func har_ms(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return -1
+	}
+	return float64(end.Sub(start)) / float64(time.Millisecond)
+}
+
+// Returns milliseconds between start and end, 0 if either didn't happen.
+func har_required_ms(start, end time.Time) float64 {
+	if ms := har_ms(start, end); ms > 0 {
+		return ms
+	}
+	return 0
+}
+
+func (t *har_transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var req_body []byte
+	if req.Body != nil {
+		var err error
+		req_body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(req_body))
+	}
+
+	tr := new(har_trace)
+	set := func(v *time.Time) {
+		tr.mutex.Lock()
+		*v = time.Now()
+		tr.mutex.Unlock()
+	}
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) { set(&tr.get_conn) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			set(&tr.got_conn)
+			if info.Conn != nil {
+				tr.mutex.Lock()
+				tr.remote_addr = info.Conn.RemoteAddr().String()
+				tr.mutex.Unlock()
+			}
+		},
+		DNSStart:             func(httptrace.DNSStartInfo) { set(&tr.dns_start) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { set(&tr.dns_done) },
+		ConnectStart:         func(string, string) { set(&tr.connect_start) },
+		ConnectDone:          func(string, string, error) { set(&tr.connect_done) },
+		TLSHandshakeStart:    func() { set(&tr.tls_start) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { set(&tr.tls_done) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { set(&tr.wrote_request) },
+		GotFirstResponseByte: func() { set(&tr.first_byte) },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	var resp_body []byte
+	if err == nil {
+		resp_body, err = ioutil.ReadAll(iotimeout.NewReadCloser(resp.Body, timeout))
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(resp_body))
+	}
+	end := time.Now()
+
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+
+	entry := har_entry{
+		StartedDateTime: start.Format("2006-01-02T15:04:05.000Z07:00"),
+		Time:            har_required_ms(start, end),
+		Request:         har_new_request(req, req_body),
+	}
+	if host, _, err := net.SplitHostPort(tr.remote_addr); err == nil {
+		entry.ServerIPAddress = host
+	}
+
+	sent := tr.got_conn
+	if sent.IsZero() {
+		sent = start
+	}
+	entry.Timings = har_timings{
+		Blocked: har_ms(start, tr.get_conn),
+		DNS:     har_ms(tr.dns_start, tr.dns_done),
+		Connect: har_ms(tr.connect_start, tr.connect_done),
+		SSL:     har_ms(tr.tls_start, tr.tls_done),
+		Send:    har_required_ms(sent, tr.wrote_request),
+		Wait:    har_required_ms(tr.wrote_request, tr.first_byte),
+		Receive: har_required_ms(tr.first_byte, end),
+	}
+	// In HAR, connect includes the TLS handshake.
+	if entry.Timings.Connect >= 0 && entry.Timings.SSL > 0 {
+		entry.Timings.Connect += entry.Timings.SSL
+	}
+
+	if err != nil {
+		entry.Response = har_response{
+			Cookies:     make([]har_nv, 0),
+			Headers:     make([]har_nv, 0),
+			HeadersSize: -1,
+			BodySize:    -1,
+		}
+		entry.Comment = err.Error()
+	} else {
+		entry.Response = har_new_response(resp, resp_body)
+	}
+
+	t.recorder.add(entry)
+
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Converts headers to HAR name/value pairs, hiding credentials.
+func har_headers(header http.Header) []har_nv {
+	output := make([]har_nv, 0)
+	for k, values := range header {
+		for _, v := range values {
+			for _, hidden := range har_hidden_headers {
+				if strings.EqualFold(k, hidden) {
+					v = "[HIDDEN]"
+				}
+			}
+			output = append(output, har_nv{k, v})
+		}
+	}
+	return output
+}
+
+// Hides credentials within query parameters.
+func har_redact_query(query url.Values) url.Values {
+	for _, k := range har_hidden_params {
+		if _, ok := query[k]; ok {
+			query.Set(k, "[HIDDEN]")
+		}
+	}
+	return query
+}
+
+// Builds the HAR request.
+func har_new_request(req *http.Request, body []byte) har_request {
+	u := *req.URL
+	query := har_redact_query(u.Query())
+	if u.RawQuery != NONE {
+		u.RawQuery = query.Encode()
+	}
+
+	output := har_request{
+		Method:      req.Method,
+		URL:         u.String(),
+		HTTPVersion: req.Proto,
+		Cookies:     make([]har_nv, 0),
+		Headers:     har_headers(req.Header),
+		QueryString: make([]har_nv, 0),
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+	if output.HTTPVersion == NONE {
+		output.HTTPVersion = "HTTP/1.1"
+	}
+	for k, values := range query {
+		for _, v := range values {
+			output.QueryString = append(output.QueryString, har_nv{k, v})
+		}
+	}
+
+	if len(body) > 0 {
+		mime := req.Header.Get("Content-Type")
+		text := string(body)
+		if strings.HasPrefix(mime, "application/x-www-form-urlencoded") {
+			text = redact_form(text)
+		} else if strings.HasPrefix(mime, "application/json") {
+			text = redact_json(body)
+		}
+		output.PostData = &har_postdata{MimeType: mime, Text: text}
+	}
+	return output
+}
+
+// Builds the HAR response.
+func har_new_response(resp *http.Response, body []byte) har_response {
+	header := resp.Header.Clone()
+	output := har_response{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Cookies:     make([]har_nv, 0),
+		Headers:     har_headers(header),
+		HeadersSize: -1,
+		BodySize:    len(body),
+		Content: har_content{
+			Size:     len(body),
+			MimeType: header.Get("Content-Type"),
+		},
+	}
+	if output.HTTPVersion == NONE {
+		output.HTTPVersion = "HTTP/1.1"
+	}
+	output.RedirectURL = header.Get("Location")
+
+	if utf8.Valid(body) {
+		output.Content.Text = redact_json(body)
+	} else {
+		output.Content.Text = base64.StdEncoding.EncodeToString(body)
+		output.Content.Encoding = "base64"
+	}
+	return output
+}
+
+// Completes the HAR file.
+func (h *har_recorder) save() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	_, err := fmt.Fprintf(h.file, "\n    ]\n  }\n}\n")
+	if cerr := h.file.Close(); err == nil {
+		err = cerr
+	}
+	if h.err != nil {
+		return
+	}
+	if err != nil {
+		Err("Unable to save HAR archive: %s", err.Error())
+		return
+	}
+	Log("Saved %d API requests to %s.", h.entries, h.filename)
+}
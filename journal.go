@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Run statuses.
+const (
+	RUN_RUNNING     = "running"
+	RUN_COMPLETED   = "completed"
+	RUN_FAILED      = "failed"
+	RUN_CANCELLED   = "cancelled"
+	RUN_ROLLED_BACK = "rolled back"
+)
+
+// Kinds of journal entries.
+const (
+	JOURNAL_EXPIRY        = "expiry"
+	JOURNAL_NOTIFICATIONS = "notifications"
+)
+
+// Table holding the record of each run.
+const runs_table = "runs"
+
+// Record of a task run, kept in kitetool.db.
+type run_record struct {
+	ID      string   `json:"id"`
+	Task    string   `json:"task"`
+	Args    []string `json:"args"`
+	Started string   `json:"started"`
+	Ended   string   `json:"ended"`
+	Status  string   `json:"status"`
+	DryRun  bool     `json:"dry_run"`
+	Offset  int      `json:"offset"`
+	Profile string   `json:"profile"`
+}
+
+// Prior state of a folder, saved before a run changes it.
+type journal_entry struct {
+	Kind         string `json:"kind"`
+	User         string `json:"user"`
+	FolderID     int    `json:"folder_id"`
+	Path         string `json:"folder_path"`
+	Expire       string `json:"expire"`
+	FileLifetime int    `json:"file_lifetime"`
+	FileAdded    bool   `json:"file_added"`
+	CommentAdded bool   `json:"comment_added"`
+	Recorded     string `json:"recorded"`
+}
+
+// Current run.
+var current_run struct {
+	mutex  sync.Mutex
+	record *run_record
+	last   string // ID of the last run to end.
+}
+
+// Generates a run id, ie.. 20200214-153000-a1b2.
+func new_run_id() string {
+	b := make([]byte, 2)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%x", time.Now().Format("20060102-150405"), b)
+}
+
+// Table holding the journal for run id.
+func journal_table(run_id string) string {
+	return fmt.Sprintf("journal_%s", run_id)
+}
+
+// Starts a new run of task, recording it in the runs table, or picks up the run being resumed.
+func begin_run(task string, args []string) *run_record {
+	current_run.mutex.Lock()
+	defer current_run.mutex.Unlock()
+
+	if global.resume != NONE {
+		record, found := get_run(global.resume)
+		if found {
+			record.Status = RUN_RUNNING
+			record.Ended = NONE
+			current_run.record = &record
+			global.db.Set(runs_table, record.ID, current_run.record)
+			return current_run.record
+		}
+	}
+
+	current_run.record = &run_record{
+		ID:      new_run_id(),
+		Task:    task,
+		Args:    args,
+		Started: time.Now().UTC().Format(time.RFC3339),
+		Status:  RUN_RUNNING,
+		DryRun:  global.dry_run,
+		Profile: global.profile,
+	}
+	global.db.Set(runs_table, current_run.record.ID, current_run.record)
+	return current_run.record
+}
+
+// Ends the current run with status.
+func end_run(status string) {
+	current_run.mutex.Lock()
+	defer current_run.mutex.Unlock()
+
+	r := current_run.record
+	if r == nil {
+		return
+	}
+	r.Status = status
+	r.Ended = time.Now().UTC().Format(time.RFC3339)
+	global.db.Set(runs_table, r.ID, r)
+	current_run.record = nil
+	current_run.last = r.ID
+
+	if _, failed := progress_counts(r.ID); failed > 0 {
+		Log("Run ID: %s, %d users failed, retry them with --resume %s.", r.ID, failed, r.ID)
+	} else {
+		Log("Run ID: %s", r.ID)
+	}
+}
+
+// Returns an error if the run was made against a different profile than the one selected.
+func (r run_record) check_profile() error {
+	if r.Profile != NONE && r.Profile != global.profile {
+		return fmt.Errorf("Run '%s' was made with profile '%s', select it with --profile %s.", r.ID, r.Profile, r.Profile)
+	}
+	return nil
+}
+
+// Returns the id of the current run.
+func run_id() string {
+	current_run.mutex.Lock()
+	defer current_run.mutex.Unlock()
+	if current_run.record == nil {
+		return NONE
+	}
+	return current_run.record.ID
+}
+
+// Returns the id of the last run to end.
+func last_run_id() string {
+	current_run.mutex.Lock()
+	defer current_run.mutex.Unlock()
+	return current_run.last
+}
+
+// Returns the run record for id.
+func get_run(id string) (record run_record, found bool) {
+	found = global.db.Get(runs_table, id, &record)
+	return
+}
+
+// Returns all runs, oldest first.
+func list_runs() (runs []run_record) {
+	for _, k := range global.db.ListKeys(runs_table) {
+		if r, found := get_run(k); found {
+			runs = append(runs, r)
+		}
+	}
+	return
+}
+
+// Saves entry to the journal of the current run, only the first state seen of a folder is kept.
+func journal(entry journal_entry) {
+	id := run_id()
+	if id == NONE || global.dry_run {
+		return
+	}
+
+	key := fmt.Sprintf("%s:%d", entry.Kind, entry.FolderID)
+	if entry.Kind == JOURNAL_NOTIFICATIONS {
+		// Notifications are a per-user setting.
+		key = fmt.Sprintf("%s:%s", key, strings.ToLower(entry.User))
+	}
+	entry.Recorded = time.Now().UTC().Format(time.RFC3339)
+
+	current_run.mutex.Lock()
+	defer current_run.mutex.Unlock()
+
+	var existing journal_entry
+	if global.db.Get(journal_table(id), key, &existing) {
+		return
+	}
+	global.db.Set(journal_table(id), key, &entry)
+}
+
+// Returns the journal of run id, parent folders before the folders nested within them.
+func read_journal(run_id string) (entries []journal_entry) {
+	table := journal_table(run_id)
+	for _, k := range global.db.ListKeys(table) {
+		var entry journal_entry
+		if global.db.Get(table, k, &entry) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if da, db := strings.Count(a.Path, "/"), strings.Count(b.Path, "/"); da != db {
+			return da < db
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.FolderID != b.FolderID {
+			return a.FolderID < b.FolderID
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return strings.ToLower(a.User) < strings.ToLower(b.User)
+	})
+	return
+}
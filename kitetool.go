@@ -14,6 +14,23 @@ import (
 var global struct {
 	kw_server   string
 	snoop       bool
+	dry_run     bool
+	threads     int
+	rate_limit  int
+	record_file string
+	replay_file string
+	cassette    *cassette
+	har_file    string
+	har         *har_recorder
+	metrics     string
+	report_file string
+	profile     string
+	config_file string
+	resolve     string
+	report      *change_report
+	resume      string
+	log_format  string
+	task_name   string
 	timeout     time.Duration
 	db          database
 	cache       database
@@ -21,12 +38,13 @@ var global struct {
 	start_time  time.Time
 	config      Config
 	user_list   []string
+	playbook    bool
 	menu        menu
 	errors      stats_record
 	mutex       sync.Mutex
 }
 
-var api_call_bank = make(chan interface{}, MAX_CONNECTIONS)
+var api_call_bank = new(call_bank)
 
 const (
 	MAX_CONNECTIONS = 3
@@ -44,9 +62,7 @@ const (
 var VERSION_STRING = fmt.Sprintf("%d.%d.%d", RELEASE_YEAR, RELEASE_MONTH, RELEASE_MINOR)
 
 func init() {
-	for i := 0; i < MAX_CONNECTIONS; i++ {
-		api_call_bank <- struct{}{}
-	}
+	api_call_bank.Configure(MAX_CONNECTIONS, 0)
 	var err error
 	global.cache.db, err = kvlite.MemStore()
 	errchk(err)
@@ -65,6 +81,9 @@ func main() {
 	flag := eflag.NewFlagSet(os.Args[0], eflag.ReturnErrorOnly)
 	//flag.Header = fmt.Sprintf("-- %s kiteworks Admin Assistant (%v)\n", APPNAME, VERSION_STRING)
 	setup_requested := flag.Bool("setup", false, "Configure API settings for kiteworks appliance.")
+	profile := flag.String("profile", "<name>", "Use the named profile's API settings, see profiles command.")
+	resolve := flag.String("resolve", "<host:ip>", "Connect to ip for host while keeping host for TLS, ie.. kiteworks.domain.com:10.0.0.5.")
+	config_file := flag.String("config-file", "<config.json|config.yml>", "Load API settings from a json or yaml file, overrides saved settings.")
 
 	if err := flag.Parse(os.Args[1:]); err != nil {
 		if err != eflag.ErrHelp {
@@ -78,16 +97,19 @@ func main() {
 	}
 
 	Defer(global.db.db.Close)
+	Defer(release_tokens)
+	Defer(run_task_defers)
 
-	global.db.Get(APPNAME, "config", &global.config)
+	handle_interrupts()
 
-	request_help := false
+	migrate_legacy_config()
+	errchk(select_profile(*profile))
+	global.config_file = *config_file
+	global.resolve = *resolve
 
-	for _, x := range flag.Args() {
-		if strings.Contains(strings.ToLower(x), "--help") || strings.Contains(strings.ToLower(x), "-h") {
-			request_help = true
-		}
-	}
+	// Settings from file and environment override saved settings, but are not saved.
+	global.config, _ = saved_config()
+	errchk(global.config.apply_overrides())
 
 	if len(os.Args) < 2 {
 		Stderr(header)
@@ -95,18 +117,51 @@ func main() {
 		global.menu.Show()
 		os.Exit(0)
 	} else {
-		if !request_help {
+		if needs_setup(flag.Args(), *setup_requested) {
 			// Load API Configuration
 			setup(*setup_requested)
 		}
 
 		if err = global.menu.Select(flag.Args()); err != nil {
+			if err == ErrTaskFailed {
+				Exit(1)
+			}
 			Stderr(err.Error())
 			flag.Usage()
 			global.menu.Show()
 		} else {
-			Log("\n")
-			Log("Process completed in %s with %d errors.", time.Now().Sub(global.start_time).Round(time.Second).String(), global.errors)
+			show_summary()
+		}
+	}
+}
+
+// Returns true if args ask for a task's help, flags are matched exactly so ie.. --har isn't taken for -h.
+func help_requested(args []string) bool {
+	for _, x := range args {
+		switch strings.ToLower(x) {
+		case "--help", "-help", "-h", "--h":
+			return true
 		}
 	}
+	return false
+}
+
+// Returns true if the API settings need to be loaded before running args.
+func needs_setup(args []string, setup_requested bool) bool {
+	return !help_requested(args) && (setup_requested || global.menu.NeedsAPI(args))
+}
+
+// Shows the summary of the task that just ran.
+func show_summary() {
+	Log("\n")
+	if cancelled() {
+		Log("Process cancelled after %s with %d errors.", time.Now().Sub(global.start_time).Round(time.Second).String(), global.errors)
+	} else {
+		Log("Process completed in %s with %d errors.", time.Now().Sub(global.start_time).Round(time.Second).String(), global.errors)
+	}
+	show_conn_stats()
+	show_metrics()
+	if global.dry_run {
+		dry_run_report()
+	}
 }
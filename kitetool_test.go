@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHelpRequested(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"mail-cleanup", "--help"}, true},
+		{[]string{"mail-cleanup", "-help"}, true},
+		{[]string{"mail-cleanup", "-h"}, true},
+		{[]string{"mail-cleanup", "--h"}, true},
+		{[]string{"mail-cleanup", "--HELP"}, true},
+		{[]string{"mail-cleanup", "--har", "out.har"}, false},
+		{[]string{"mail-cleanup", "--har=out.har"}, false},
+		{[]string{"mail-cleanup", "--expire-drafts", "2020-01-01"}, false},
+	}
+	for _, tt := range tests {
+		if got := help_requested(tt.args); got != tt.want {
+			t.Errorf("help_requested(%q) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestNeedsSetupWithHar(t *testing.T) {
+	if !needs_setup([]string{"mail-cleanup", "--har", "out.har"}, false) {
+		t.Errorf("needs_setup with --har = false, want true")
+	}
+	if needs_setup([]string{"mail-cleanup", "--help"}, false) {
+		t.Errorf("needs_setup with --help = true, want false")
+	}
+	if needs_setup([]string{"config", "--har", "out.har"}, false) {
+		t.Errorf("needs_setup for a local command = true, want false")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"github.com/cmcoffee/go-nfo"
+	"sync"
+	"time"
+)
+
+const (
+	min_backoff = time.Duration(250 * time.Millisecond)
+	max_backoff = time.Duration(30 * time.Second)
+)
+
+// Limits concurrent and per-second API calls, backing off when kiteworks reports it is overloaded.
+// Configure may run while calls from an earlier task are still in flight, so slots are counted
+// rather than held in a channel that a reconfigure would swap out from under them.
+type call_bank struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	limit    int           // Concurrent calls allowed.
+	in_use   int           // Calls in flight.
+	interval time.Duration // Minimum spacing between calls from requests per second.
+	backoff  time.Duration // Adaptive spacing added while kiteworks is overloaded.
+	next     time.Time
+}
+
+// Sets the number of concurrent calls and the requests per second, rps of 0 is unlimited.
+func (b *call_bank) Configure(threads, rps int) {
+	if threads <= 0 {
+		threads = MAX_CONNECTIONS
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.cond == nil {
+		b.cond = sync.NewCond(&b.mutex)
+	}
+	b.limit = threads
+	// Wake callers waiting on the old limit, they may fit under the new one.
+	b.cond.Broadcast()
+
+	if rps > 0 {
+		b.interval = time.Second / time.Duration(rps)
+	} else {
+		b.interval = 0
+	}
+	b.backoff = 0
+	b.next = time.Time{}
+}
+
+// Takes a call slot, waiting until the rate limit allows the call, or until ctx is cancelled.
+func (b *call_bank) Take(ctx context.Context) error {
+	b.mutex.Lock()
+
+	if b.cond == nil {
+		b.cond = sync.NewCond(&b.mutex)
+	}
+
+	if b.in_use >= b.limit {
+		// Wake the wait below if ctx is cancelled while waiting for a slot.
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				b.mutex.Lock()
+				b.cond.Broadcast()
+				b.mutex.Unlock()
+			case <-stop:
+			}
+		}()
+		for b.in_use >= b.limit {
+			if err := ctx.Err(); err != nil {
+				b.mutex.Unlock()
+				return err
+			}
+			b.cond.Wait()
+		}
+	}
+	b.in_use++
+
+	spacing := b.interval
+	if b.backoff > spacing {
+		spacing = b.backoff
+	}
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	wait := b.next.Sub(now)
+	b.next = b.next.Add(spacing)
+	b.mutex.Unlock()
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			b.Return()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Returns a call slot to the bank.
+func (b *call_bank) Return() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.in_use > 0 {
+		b.in_use--
+	}
+	if b.cond != nil {
+		b.cond.Broadcast()
+	}
+}
+
+// Increases spacing between calls, called when kiteworks reports it is unavailable.
+func (b *call_bank) Throttle() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.backoff = b.backoff * 2
+	if b.backoff < min_backoff {
+		b.backoff = min_backoff
+	}
+	if b.backoff > max_backoff {
+		b.backoff = max_backoff
+	}
+	nfo.Debug("kiteworks is overloaded, spacing requests %s apart.", b.backoff.String())
+}
+
+// Eases off added spacing after a successful call.
+func (b *call_bank) Relax() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.backoff == 0 {
+		return
+	}
+	b.backoff = b.backoff - b.backoff/4
+	if b.backoff < min_backoff/4 {
+		b.backoff = 0
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cmcoffee/go-nfo"
+	"strings"
+	"time"
+)
+
+// Log output formats.
+const (
+	LOG_TEXT = "text"
+	LOG_JSON = "json"
+)
+
+// Event within a task, carries the details for structured logging.
+type Event struct {
+	User     string      // Acting user.
+	FolderID int         // Folder being acted upon.
+	Path     string      // Path of folder being acted upon.
+	Action   string      // Action being taken, ie.. "set_expiry".
+	Old      interface{} // Value prior to the action.
+	New      interface{} // Value after the action.
+}
+
+// JSON log record.
+type log_record struct {
+	Timestamp string      `json:"timestamp"`
+	Level     string      `json:"level"`
+	Task      string      `json:"task,omitempty"`
+	User      string      `json:"user,omitempty"`
+	FolderID  int         `json:"folder_id,omitempty"`
+	Path      string      `json:"folder_path,omitempty"`
+	Action    string      `json:"action,omitempty"`
+	Old       interface{} `json:"old_value,omitempty"`
+	New       interface{} `json:"new_value,omitempty"`
+	Code      string      `json:"error_code,omitempty"`
+	Message   string      `json:"message"`
+}
+
+// Returns true when logging as JSON.
+func log_json() bool {
+	return global.log_format == LOG_JSON
+}
+
+// Formats log input the same way nfo does.
+func log_sprint(input ...interface{}) string {
+	if len(input) == 0 {
+		return NONE
+	}
+	if format, ok := input[0].(string); ok && len(input) > 1 {
+		return fmt.Sprintf(format, input[1:]...)
+	}
+	return fmt.Sprint(input...)
+}
+
+// Writes a JSON log record for e.
+func (e Event) write(level string, err error, input ...interface{}) {
+	msg := strings.TrimSpace(log_sprint(input...))
+	if msg == NONE {
+		return
+	}
+
+	record := log_record{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Level:     level,
+		Task:      global.task_name,
+		User:      e.User,
+		FolderID:  e.FolderID,
+		Path:      e.Path,
+		Action:    e.Action,
+		Old:       e.Old,
+		New:       e.New,
+		Code:      error_code(err),
+		Message:   msg,
+	}
+
+	output, jerr := json.Marshal(&record)
+	if jerr != nil {
+		nfo.Log(msg)
+		return
+	}
+	nfo.Log("%s", string(output))
+}
+
+// Logs event.
+func (e Event) Log(input ...interface{}) {
+	if log_json() {
+		e.write("info", nil, input...)
+		return
+	}
+	nfo.Log(input...)
+}
+
+// Logs event as an error, err supplies the error code.
+func (e Event) Err(err error, input ...interface{}) {
+	global.errors.Add(1)
+	if log_json() {
+		e.write("error", err, input...)
+		return
+	}
+	nfo.Err(input...)
+}
+
+// Logs event as a warning.
+func (e Event) Warn(input ...interface{}) {
+	if log_json() {
+		e.write("warn", nil, input...)
+		return
+	}
+	nfo.Warn(input...)
+}
+
+func Log(input ...interface{}) {
+	Event{}.Log(input...)
+}
+
+func Warn(input ...interface{}) {
+	Event{}.Warn(input...)
+}
+
+func Err(input ...interface{}) {
+	var err error
+	for _, v := range input {
+		if e, ok := v.(error); ok {
+			err = e
+			break
+		}
+	}
+	Event{}.Err(err, input...)
+}
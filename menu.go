@@ -8,6 +8,7 @@ import (
 	"strings"
 	"sync"
 	"text/tabwriter"
+	"time"
 )
 
 // Menu for tasks.
@@ -19,6 +20,16 @@ type menu struct {
 
 // Registers a task with the task menu.
 func (m *menu) Register(name, desc string, exec func(*task) error, required_params ...string) {
+	my_entry := m.register(name, desc, exec, required_params...)
+	my_entry.api = true
+}
+
+// Registers a local command with the task menu, commands run without an API session.
+func (m *menu) RegisterCommand(name, desc string, exec func(*task) error, required_params ...string) {
+	m.register(name, desc, exec, required_params...)
+}
+
+func (m *menu) register(name, desc string, exec func(*task) error, required_params ...string) *task {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	if m.entries == nil {
@@ -29,12 +40,50 @@ func (m *menu) Register(name, desc string, exec func(*task) error, required_para
 		desc:     desc,
 		exec:     exec,
 		required: required_params[0:],
-		EFlagSet: eflag.NewFlagSet(fmt.Sprintf("%s", name), eflag.ReturnErrorOnly),
 	}
-	my_entry := m.entries[name]
-	my_entry.EFlagSet.Header = fmt.Sprintf("desc: \"%s\"\n", desc)
-	my_entry.BoolVar(&global.snoop, "snoop", false, "")
-	my_entry.users = my_entry.EFlagSet.String("user", "<user@domain.com>", "Single out users for specified task, use comma seperated value for multi-user.")
+	return m.entries[name]
+}
+
+// Returns a fresh copy of the task with its own flags, so a task can be run more than once.
+func (t *task) instance(args []string) *task {
+	x := &task{
+		name:     t.name,
+		desc:     t.desc,
+		exec:     t.exec,
+		required: append([]string(nil), t.required...),
+		args:     args,
+		api:      t.api,
+		EFlagSet: eflag.NewFlagSet(fmt.Sprintf("%s", t.name), eflag.ReturnErrorOnly),
+	}
+	x.EFlagSet.Header = fmt.Sprintf("desc: \"%s\"\n", t.desc)
+	if x.api {
+		x.BoolVar(&global.snoop, "snoop", false, "")
+		x.BoolVar(&global.dry_run, "dry-run", false, "Preview changes, no changes will be sent to kiteworks.")
+		x.IntVar(&global.threads, "threads", 0, "Concurrent API requests, overrides configured setting.")
+		x.IntVar(&global.rate_limit, "rps", 0, "API requests per second, 0 for unlimited, overrides configured setting.")
+		x.StringVar(&global.record_file, "record", "<cassette.json>", "Record API traffic to file, tokens are redacted.")
+		x.StringVar(&global.replay_file, "replay", "<cassette.json>", "Replay API responses from a recording instead of the network.")
+		x.StringVar(&global.har_file, "har", "<session.har>", "Write API traffic to a HAR archive, credentials are redacted.")
+		x.StringVar(&global.metrics, "metrics", "<kitetool.prom>", "Write API metrics to a file in Prometheus textfile format.")
+		x.StringVar(&global.report_file, "report", "<out.csv|out.json>", "Write a report of changes made to a csv or json file.")
+		x.StringVar(&global.resume, "resume", "<run id>", "Resume an interrupted run, skipping users that already finished.")
+		x.StringVar(&global.log_format, "log-format", LOG_TEXT, "Log output format, text or json.")
+		x.users = x.EFlagSet.String("user", "<user@domain.com>", "Single out users for specified task, use comma seperated value for multi-user.")
+	}
+	return x
+}
+
+// Returns true if the selected task requires an API session, unknown tasks are treated as requiring one.
+func (m *menu) NeedsAPI(args []string) bool {
+	if len(args) == 0 {
+		return true
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if x, ok := m.entries[args[0]]; ok {
+		return x.api
+	}
+	return true
 }
 
 // Write out menu item.
@@ -71,29 +120,105 @@ func (m *menu) Show() {
 	os.Stderr.Write([]byte(fmt.Sprintf("For extended help on any task, type %s <command> --help.\n", APPNAME)))
 }
 
+// Returned by Select when the task failed, the error has already been shown.
+const ErrTaskFailed = Error("Task failed.")
+
+// Returns the names of all tasks.
+func (m *menu) Names() (names []string) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	for k := range m.entries {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return
+}
+
 // Select a specific task.
 func (m *menu) Select(args []string) (err error) {
 	if len(args) == 0 {
 		m.Show()
-	} else {
-		m.mutex.RLock()
-		if x, ok := m.entries[args[0]]; ok {
-			x.args = args[1:]
-			m.mutex.RUnlock()
-
-			if err := x.exec(x); err != nil {
-				if err != eflag.ErrHelp {
-					Stderr("[ERROR] %s\n\n", err.Error())
-				}
-				x.EFlagSet.Usage()
-				os.Exit(1)
-			}
-		} else {
-			m.mutex.RUnlock()
-			return fmt.Errorf("[ERROR] No such task: '%s' found.\n\n", args[0])
+		return nil
+	}
+
+	m.mutex.RLock()
+	entry, ok := m.entries[args[0]]
+	m.mutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("[ERROR] No such task: '%s' found.\n\n", args[0])
+	}
+
+	reset_run()
+	defer run_task_defers()
+
+	x := entry.instance(args[1:])
+	global.task_name = x.name
+
+	err = x.exec(x)
+	if cancelled() {
+		end_run(RUN_CANCELLED)
+		return nil
+	}
+	if err != nil {
+		end_run(RUN_FAILED)
+		// The task has already reported why it failed, so its usage isn't shown.
+		if err == ErrTaskFailed {
+			return err
+		}
+		if err != eflag.ErrHelp {
+			Stderr("[ERROR] %s\n\n", err.Error())
 		}
+		x.EFlagSet.Usage()
+		return ErrTaskFailed
 	}
-	return
+	end_run(RUN_COMPLETED)
+	return nil
+}
+
+// Finalizers of the running task, such as saving its report.
+var task_defers struct {
+	mutex sync.Mutex
+	funcs []func()
+}
+
+// Runs fn when the running task ends, or when the program exits before it does.
+func task_defer(fn func()) {
+	task_defers.mutex.Lock()
+	defer task_defers.mutex.Unlock()
+	task_defers.funcs = append(task_defers.funcs, fn)
+}
+
+// Runs the finalizers of the task, last in first out.
+func run_task_defers() {
+	task_defers.mutex.Lock()
+	funcs := task_defers.funcs
+	task_defers.funcs = nil
+	task_defers.mutex.Unlock()
+
+	for i := len(funcs) - 1; i >= 0; i-- {
+		funcs[i]()
+	}
+}
+
+// Clears state left behind by a previous task, so each task run from the shell starts fresh.
+// Steps of a playbook keep the counters of the steps before them, so the playbook ends with one summary.
+func reset_run() {
+	reset_cancel()
+	global.cassette = nil
+	global.har = nil
+	global.report = nil
+	global.user_list = nil
+	if global.playbook {
+		return
+	}
+	reset_metrics()
+	global.errors.Set(0)
+	global.start_time = time.Now()
+	conn_stats.opened.Set(0)
+	conn_stats.reused.Set(0)
+	dry_run.mutex.Lock()
+	dry_run.changes = nil
+	dry_run.mutex.Unlock()
 }
 
 // Menu item.
@@ -104,12 +229,29 @@ type task struct {
 	required []string
 	args     []string
 	users    *string
+	api      bool
 	*eflag.EFlagSet
 }
 
-// Message to show when task is starting.
+// Message to show when task is starting, begins the run record.
 func (m *task) LogStart() {
-	Log("--> %s '%s' started..", APPNAME, m.name)
+	if m.api {
+		begin_run(m.name, m.args)
+	}
+	m.ShowStart()
+}
+
+// Message to show when task is starting, for tasks that journal nothing and so have no run record.
+func (m *task) ShowStart() {
+	if global.resume != NONE {
+		done, failed := progress_counts(global.resume)
+		Log("--> Resuming run %s, %d users finished, %d users failed and will be retried.", global.resume, done, failed)
+	}
+	if global.dry_run {
+		Log("--> %s '%s' started in dry-run mode, no changes will be made..", APPNAME, m.name)
+	} else {
+		Log("--> %s '%s' started..", APPNAME, m.name)
+	}
 	Log("\n")
 }
 
@@ -119,6 +261,70 @@ func (m *task) Parse() (err error) {
 		return err
 	}
 
+	// Pick up the arguments of the run being resumed.
+	if global.resume != NONE {
+		record, err := load_resume(m.name, global.resume)
+		if err != nil {
+			return err
+		}
+		if err = m.EFlagSet.Parse(record.Args); err != nil {
+			return err
+		}
+		global.resume = record.ID
+	}
+
+	threads := global.config.Threads
+	if m.IsSet("threads") {
+		threads = global.threads
+	}
+
+	rate_limit := global.config.RateLimit
+	if m.IsSet("rps") {
+		rate_limit = global.rate_limit
+	}
+
+	api_call_bank.Configure(threads, rate_limit)
+
+	global.log_format = strings.ToLower(global.log_format)
+	switch global.log_format {
+	case NONE, LOG_TEXT, LOG_JSON:
+	default:
+		return fmt.Errorf("Unknown --log-format '%s', should be %s or %s.", global.log_format, LOG_TEXT, LOG_JSON)
+	}
+
+	if global.record_file != NONE && global.replay_file != NONE {
+		return Error("--record and --replay are mutually exclusive options.")
+	}
+
+	if global.replay_file != NONE {
+		if global.cassette, err = replay_cassette(global.replay_file); err != nil {
+			return err
+		}
+	} else if global.record_file != NONE {
+		global.cassette = record_cassette(global.record_file)
+	}
+
+	if global.har_file != NONE {
+		if global.har, err = record_har(global.har_file); err != nil {
+			return err
+		}
+	}
+
+	if global.metrics != NONE {
+		filename := global.metrics
+		task_defer(func() {
+			if err := write_metrics(filename); err != nil {
+				Err("Unable to write metrics: %s", err.Error())
+			}
+		})
+	}
+
+	if global.report_file != NONE {
+		if global.report, err = new_report(global.report_file); err != nil {
+			return err
+		}
+	}
+
 	if m.users != nil {
 		for _, u := range strings.Split(*m.users, ",") {
 			if u != NONE {
@@ -138,9 +344,7 @@ func (m *task) Parse() (err error) {
 	}
 
 	if len(m.required) > 0 {
-		Stderr("[ERROR] Missing manadatory arguments: %v\n", strings.Join(m.required, ", "))
-		m.Usage()
-		Exit(1)
+		return fmt.Errorf("Missing manadatory arguments: %v", strings.Join(m.required, ", "))
 	}
 	return nil
 }
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Upper bounds of the request latency histogram, in seconds.
+var latency_buckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Matches ids within API paths, so requests for different objects share an endpoint.
+var endpoint_ids = regexp.MustCompile(`/[0-9]+(/|$)`)
+
+// Statistics of a single endpoint.
+type endpoint_metrics struct {
+	method   string
+	path     string
+	requests int64
+	errors   int64
+	retries  int64
+	buckets  []int64
+	seconds  float64
+}
+
+// API metrics collected over the run.
+var api_metrics struct {
+	mutex       sync.Mutex
+	endpoints   map[string]*endpoint_metrics
+	error_codes map[string]int64
+	token_mints stats_record
+}
+
+// Clears metrics collected by a previous task.
+func reset_metrics() {
+	api_metrics.mutex.Lock()
+	defer api_metrics.mutex.Unlock()
+	api_metrics.endpoints = nil
+	api_metrics.error_codes = nil
+	api_metrics.token_mints.Set(0)
+}
+
+// Returns the endpoint of path, with ids replaced. ie.. /rest/folders/101/members -> /rest/folders/{id}/members
+func endpoint_path(path string) string {
+	if i := strings.Index(path, "?"); i > -1 {
+		path = path[:i]
+	}
+	for endpoint_ids.MatchString(path) {
+		path = endpoint_ids.ReplaceAllString(path, "/{id}$1")
+	}
+	return path
+}
+
+// Returns the metrics of an endpoint, caller must hold the lock.
+func get_endpoint(method, path string) *endpoint_metrics {
+	method = strings.ToUpper(method)
+	path = endpoint_path(path)
+	key := fmt.Sprintf("%s %s", method, path)
+
+	if api_metrics.endpoints == nil {
+		api_metrics.endpoints = make(map[string]*endpoint_metrics)
+	}
+	e, ok := api_metrics.endpoints[key]
+	if !ok {
+		e = &endpoint_metrics{method: method, path: path, buckets: make([]int64, len(latency_buckets))}
+		api_metrics.endpoints[key] = e
+	}
+	return e
+}
+
+// Records a request attempt to an endpoint.
+func record_request(method, path string, elapsed time.Duration, err error) {
+	api_metrics.mutex.Lock()
+	defer api_metrics.mutex.Unlock()
+
+	e := get_endpoint(method, path)
+	e.requests++
+	e.seconds += elapsed.Seconds()
+	for i, le := range latency_buckets {
+		if elapsed.Seconds() <= le {
+			e.buckets[i]++
+		}
+	}
+
+	if err == nil {
+		return
+	}
+	e.errors++
+
+	if api_metrics.error_codes == nil {
+		api_metrics.error_codes = make(map[string]int64)
+	}
+	codes := error_code(err)
+	if codes == NONE {
+		codes = "NETWORK_ERROR"
+	}
+	for _, code := range strings.Split(codes, ",") {
+		api_metrics.error_codes[code]++
+	}
+}
+
+// Records a retried request.
+func record_retry(method, path string) {
+	api_metrics.mutex.Lock()
+	defer api_metrics.mutex.Unlock()
+	get_endpoint(method, path).retries++
+}
+
+// Returns the endpoints with metrics, sorted.
+func sorted_endpoints() (output []*endpoint_metrics) {
+	for _, e := range api_metrics.endpoints {
+		output = append(output, e)
+	}
+	sort.Slice(output, func(i, j int) bool {
+		if output[i].path == output[j].path {
+			return output[i].method < output[j].method
+		}
+		return output[i].path < output[j].path
+	})
+	return
+}
+
+// Returns the latency under which fraction of an endpoint's requests completed, as the histogram's bucket bound.
+func (e *endpoint_metrics) quantile(fraction float64) string {
+	target := int64(float64(e.requests)*fraction + 0.5)
+	for i, le := range latency_buckets {
+		if e.buckets[i] >= target {
+			return fmt.Sprintf("<%s", time.Duration(le*float64(time.Second)).String())
+		}
+	}
+	return fmt.Sprintf(">%s", time.Duration(latency_buckets[len(latency_buckets)-1]*float64(time.Second)).String())
+}
+
+// Logs API metrics for the run summary.
+func show_metrics() {
+	api_metrics.mutex.Lock()
+	defer api_metrics.mutex.Unlock()
+
+	endpoints := sorted_endpoints()
+	if len(endpoints) == 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "ENDPOINT\tREQUESTS\tERRORS\tRETRIES\tAVG\tP95\n")
+	for _, e := range endpoints {
+		avg := time.Duration(e.seconds / float64(e.requests) * float64(time.Second)).Round(time.Microsecond)
+		fmt.Fprintf(w, "%s %s\t%d\t%d\t%d\t%s\t%s\n", e.method, e.path, e.requests, e.errors, e.retries, avg, e.quantile(0.95))
+	}
+	w.Flush()
+
+	Log("\nAPI Requests:")
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		Log(line)
+	}
+
+	if mints := api_metrics.token_mints.Get(); mints > 0 {
+		Log("Tokens minted: %d", mints)
+	}
+
+	if len(api_metrics.error_codes) > 0 {
+		var codes []string
+		for k := range api_metrics.error_codes {
+			codes = append(codes, k)
+		}
+		sort.Strings(codes)
+		for i, k := range codes {
+			codes[i] = fmt.Sprintf("%s=%d", k, api_metrics.error_codes[k])
+		}
+		Log("Error codes: %s", strings.Join(codes, ", "))
+	}
+}
+
+// Escapes a Prometheus label value.
+func prom_label(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// Writes API metrics in Prometheus text format, for node_exporter's textfile collector.
+func write_metrics(filename string) error {
+	api_metrics.mutex.Lock()
+	defer api_metrics.mutex.Unlock()
+
+	var buf bytes.Buffer
+	task := prom_label(global.task_name)
+
+	header := func(name, kind, help string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	header("kitetool_run_timestamp_seconds", "gauge", "Time the run finished.")
+	fmt.Fprintf(&buf, "kitetool_run_timestamp_seconds{task=\"%s\"} %d\n", task, time.Now().Unix())
+	header("kitetool_run_duration_seconds", "gauge", "Duration of the run.")
+	fmt.Fprintf(&buf, "kitetool_run_duration_seconds{task=\"%s\"} %.3f\n", task, time.Since(global.start_time).Seconds())
+	header("kitetool_run_errors", "gauge", "Errors logged during the run.")
+	fmt.Fprintf(&buf, "kitetool_run_errors{task=\"%s\"} %d\n", task, global.errors.Get())
+	header("kitetool_token_mints_total", "counter", "Access tokens minted during the run.")
+	fmt.Fprintf(&buf, "kitetool_token_mints_total{task=\"%s\"} %d\n", task, api_metrics.token_mints.Get())
+
+	endpoints := sorted_endpoints()
+
+	labels := func(e *endpoint_metrics) string {
+		return fmt.Sprintf("task=\"%s\",method=\"%s\",endpoint=\"%s\"", task, e.method, prom_label(e.path))
+	}
+
+	header("kitetool_api_requests_total", "counter", "API requests sent, including retries.")
+	for _, e := range endpoints {
+		fmt.Fprintf(&buf, "kitetool_api_requests_total{%s} %d\n", labels(e), e.requests)
+	}
+	header("kitetool_api_request_errors_total", "counter", "API requests that failed.")
+	for _, e := range endpoints {
+		fmt.Fprintf(&buf, "kitetool_api_request_errors_total{%s} %d\n", labels(e), e.errors)
+	}
+	header("kitetool_api_retries_total", "counter", "API requests that were retried.")
+	for _, e := range endpoints {
+		fmt.Fprintf(&buf, "kitetool_api_retries_total{%s} %d\n", labels(e), e.retries)
+	}
+	header("kitetool_api_request_duration_seconds", "histogram", "Latency of API requests.")
+	for _, e := range endpoints {
+		for i, le := range latency_buckets {
+			fmt.Fprintf(&buf, "kitetool_api_request_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels(e), le, e.buckets[i])
+		}
+		fmt.Fprintf(&buf, "kitetool_api_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels(e), e.requests)
+		fmt.Fprintf(&buf, "kitetool_api_request_duration_seconds_sum{%s} %.6f\n", labels(e), e.seconds)
+		fmt.Fprintf(&buf, "kitetool_api_request_duration_seconds_count{%s} %d\n", labels(e), e.requests)
+	}
+
+	header("kitetool_api_error_codes_total", "counter", "kiteworks error codes returned.")
+	var codes []string
+	for k := range api_metrics.error_codes {
+		codes = append(codes, k)
+	}
+	sort.Strings(codes)
+	for _, k := range codes {
+		fmt.Fprintf(&buf, "kitetool_api_error_codes_total{task=\"%s\",code=\"%s\"} %d\n", task, prom_label(k), api_metrics.error_codes[k])
+	}
+
+	// Write to a temporary file and rename, so the collector never reads a partial file.
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	os.Chmod(tmp.Name(), 0644)
+	return os.Rename(tmp.Name(), filename)
+}
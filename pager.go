@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Default number of entries requested per page.
+const PAGE_SIZE = 100
+
+// kiteworks paging metadata.
+type KiteMetadata struct {
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// Pager for walking limit/offset paged kiteworks listings.
+type Pager struct {
+	Limit   int // Entries requested per page.
+	Offset  int // Offset of the next page to be requested.
+	Total   int // Total entries reported by the server, -1 if unknown.
+	session KWSession
+	req     APIRequest
+	done    bool
+}
+
+// Creates a new pager for api_req, any limit or offset in api_req is overridden by the pager.
+func (s KWSession) NewPager(api_req APIRequest, limit int) *Pager {
+	if limit <= 0 {
+		limit = PAGE_SIZE
+	}
+	return &Pager{
+		Limit:   limit,
+		Total:   -1,
+		session: s,
+		req:     api_req,
+	}
+}
+
+// Retrieves the next page into output, which must be a pointer to a slice, returns false once the listing is exhausted.
+func (p *Pager) Next(output interface{}) (more bool, err error) {
+	page := reflect.ValueOf(output)
+	if page.Kind() != reflect.Ptr || page.Elem().Kind() != reflect.Slice {
+		return false, fmt.Errorf("Pager output must be a pointer to a slice, got %T.", output)
+	}
+	page.Elem().Set(reflect.MakeSlice(page.Elem().Type(), 0, 0))
+
+	if p.done {
+		return false, nil
+	}
+
+	var KiteArray struct {
+		Data     json.RawMessage `json:"data"`
+		Metadata *KiteMetadata   `json:"metadata"`
+	}
+
+	req := p.req
+	req.Params = append(SetParams(p.req.Params...), Query{"limit": p.Limit, "offset": p.Offset})
+	req.Output = &KiteArray
+
+	if err = p.session.Call(req); err != nil {
+		return false, err
+	}
+
+	if len(KiteArray.Data) > 0 {
+		if err = json.Unmarshal(KiteArray.Data, output); err != nil {
+			return false, err
+		}
+	}
+
+	count := page.Elem().Len()
+	if count == 0 {
+		p.done = true
+		return false, nil
+	}
+
+	p.Offset = p.Offset + count
+
+	// Trust the server's total when provided, servers may cap the limit below what was asked for.
+	if KiteArray.Metadata != nil && KiteArray.Metadata.Total > 0 {
+		p.Total = KiteArray.Metadata.Total
+		if p.Offset >= p.Total {
+			p.done = true
+		}
+	} else if count < p.Limit {
+		p.done = true
+	}
+
+	return true, nil
+}
+
+// Stops the pager, subsequent calls to Next return no further pages.
+func (p *Pager) Stop() {
+	p.done = true
+}
+
+// Retrieves every entry of a paged listing into output, which must be a pointer to a slice.
+func (s KWSession) DataCall(api_req APIRequest, output interface{}) (err error) {
+	all := reflect.ValueOf(output)
+	if all.Kind() != reflect.Ptr || all.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("DataCall output must be a pointer to a slice, got %T.", output)
+	}
+
+	pager := s.NewPager(api_req, PAGE_SIZE)
+	page := reflect.New(all.Elem().Type())
+
+	for {
+		more, err := pager.Next(page.Interface())
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+		all.Elem().Set(reflect.AppendSlice(all.Elem(), page.Elem()))
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Commands that can't be run as a playbook step.
+var playbook_excluded = []string{"daemon", "run", "schedule", "shell"}
+
+// Matches ${name} variables within playbook steps.
+var playbook_var = regexp.MustCompile(`\$\{([A-Za-z0-9_.-]+)\}`)
+
+// List of values, given as a yaml/json list or a comma separated string.
+type playbook_list []string
+
+func (l *playbook_list) set(input interface{}) error {
+	*l = nil
+	switch v := input.(type) {
+	case nil:
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != NONE {
+				*l = append(*l, s)
+			}
+		}
+	case []interface{}:
+		for _, s := range v {
+			*l = append(*l, fmt.Sprintf("%v", s))
+		}
+	default:
+		return fmt.Errorf("expected a list or comma separated string, got '%v'", v)
+	}
+	return nil
+}
+
+func (l *playbook_list) UnmarshalJSON(data []byte) error {
+	var input interface{}
+	if err := json.Unmarshal(data, &input); err != nil {
+		return err
+	}
+	return l.set(input)
+}
+
+func (l *playbook_list) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var input interface{}
+	if err := unmarshal(&input); err != nil {
+		return err
+	}
+	return l.set(input)
+}
+
+// Playbook of tasks run in order.
+type playbook struct {
+	Name            string                 `json:"name" yaml:"name"`
+	Users           playbook_list          `json:"users" yaml:"users"`
+	Vars            map[string]interface{} `json:"vars" yaml:"vars"`
+	ContinueOnError bool                   `json:"continue_on_error" yaml:"continue_on_error"`
+	Steps           []*playbook_step       `json:"steps" yaml:"steps"`
+}
+
+// Step of a playbook, task is the command line of the task with args appended to it.
+type playbook_step struct {
+	Name            string        `json:"name" yaml:"name"`
+	Task            string        `json:"task" yaml:"task"`
+	Args            playbook_list `json:"args" yaml:"args"`
+	Users           playbook_list `json:"users" yaml:"users"`
+	ContinueOnError *bool         `json:"continue_on_error" yaml:"continue_on_error"`
+	args            []string
+}
+
+// Reads a playbook from a json or yaml file, vars given override those of the playbook.
+func load_playbook(filename string, vars map[string]string) (*playbook, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	pb := new(playbook)
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yml", ".yaml":
+		err = yaml.UnmarshalStrict(data, pb)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(pb)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s: %s", filename, err.Error())
+	}
+
+	if pb.Name == NONE {
+		pb.Name = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+	if len(pb.Steps) == 0 {
+		return nil, fmt.Errorf("%s: playbook has no steps.", filename)
+	}
+
+	values := make(map[string]string)
+	for k, v := range pb.Vars {
+		if v != nil {
+			values[k] = fmt.Sprintf("%v", v)
+		} else {
+			values[k] = NONE
+		}
+	}
+	for k, v := range vars {
+		values[k] = v
+	}
+
+	for i, u := range pb.Users {
+		if pb.Users[i], err = expand_vars(u, values); err != nil {
+			return nil, fmt.Errorf("%s: users: %s", filename, err.Error())
+		}
+	}
+
+	for i, step := range pb.Steps {
+		if err := step.prepare(values); err != nil {
+			return nil, fmt.Errorf("%s: step %d: %s", filename, i+1, err.Error())
+		}
+		if step.Name == NONE {
+			step.Name = step.args[0]
+		}
+	}
+	return pb, nil
+}
+
+// Expands the variables within input, returns an error for variables that aren't set.
+func expand_vars(input string, vars map[string]string) (string, error) {
+	var missing []string
+	output := playbook_var.ReplaceAllStringFunc(input, func(v string) string {
+		name := playbook_var.FindStringSubmatch(v)[1]
+		value, ok := vars[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return NONE, fmt.Errorf("variable '%s' is not set, set it in vars or with %s=<value>.", missing[0], missing[0])
+	}
+	return output, nil
+}
+
+// Builds the step's arguments, expanding variables and checking the task can be run.
+func (s *playbook_step) prepare(vars map[string]string) (err error) {
+	// Split before expanding, so values with spaces stay one argument.
+	args, err := shell_split(s.Task)
+	if err != nil {
+		return err
+	}
+	s.args = nil
+	for _, a := range append(args, s.Args...) {
+		if a, err = expand_vars(a, vars); err != nil {
+			return err
+		}
+		s.args = append(s.args, a)
+	}
+	for i, u := range s.Users {
+		if s.Users[i], err = expand_vars(u, vars); err != nil {
+			return err
+		}
+	}
+
+	if len(s.args) == 0 {
+		return fmt.Errorf("no task given.")
+	}
+	for _, name := range playbook_excluded {
+		if s.args[0] == name {
+			return fmt.Errorf("the '%s' command can't be run from a playbook.", name)
+		}
+	}
+	for _, name := range global.menu.Names() {
+		if s.args[0] == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("no such task: '%s' found.", s.args[0])
+}
+
+// Returns true if args sets flag name, ie.. --user, -user or --user=x.
+func has_flag(args []string, name string) bool {
+	for _, a := range args {
+		if !strings.HasPrefix(a, "-") {
+			continue
+		}
+		a = strings.TrimLeft(a, "-")
+		if a == name || strings.HasPrefix(a, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the command line of the step.
+func (s *playbook_step) command() string {
+	return join_args(s.args)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	profiles_table  = "profiles"
+	default_profile = "default"
+)
+
+// Returns an error if name cannot be used as a profile name.
+func valid_profile_name(name string) error {
+	if name == NONE {
+		return fmt.Errorf("Profile name cannot be blank.")
+	}
+	if strings.ContainsAny(name, ": \t/\\") {
+		return fmt.Errorf("Invalid profile name '%s', names cannot contain spaces, colons or slashes.", name)
+	}
+	return nil
+}
+
+// Moves the configuration saved before profiles existed into the default profile.
+func migrate_legacy_config() {
+	var legacy Config
+	if !global.db.Get(APPNAME, "config", &legacy) {
+		return
+	}
+	if len(global.db.ListKeys(profiles_table)) == 0 {
+		global.db.CryptSet(profiles_table, default_profile, &legacy)
+		global.db.Set(APPNAME, "default_profile", default_profile)
+	}
+	global.db.Unset(APPNAME, "config")
+
+	// Tokens saved before profiles existed aren't namespaced, let them be minted again.
+	for _, k := range global.db.ListKeys(token_table) {
+		if strings.Count(k, ":") == 1 {
+			global.db.Unset(token_table, k)
+		}
+	}
+}
+
+// Returns the name of the default profile.
+func get_default_profile() (name string) {
+	if !global.db.Get(APPNAME, "default_profile", &name) || name == NONE {
+		name = default_profile
+	}
+	return
+}
+
+// Selects the profile to use, the --profile flag wins over KITETOOL_PROFILE and the default profile.
+func select_profile(name string) error {
+	if name == NONE {
+		name = os.Getenv(config_env_prefix + "PROFILE")
+	}
+	if name == NONE {
+		name = get_default_profile()
+	}
+	if err := valid_profile_name(name); err != nil {
+		return err
+	}
+	global.profile = name
+	return nil
+}
+
+// Returns the saved configuration of the selected profile.
+func saved_config() (cfg Config, found bool) {
+	found = global.db.Get(profiles_table, global.profile, &cfg)
+	return
+}
+
+// Returns all profile names.
+func list_profiles() []string {
+	names := global.db.ListKeys(profiles_table)
+	sort.Strings(names)
+	return names
+}
+
+// Returns true if profile exists.
+func profile_exists(name string) bool {
+	for _, v := range list_profiles() {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Clears saved tokens of profile.
+func clear_profile_tokens(name string) {
+	prefix := fmt.Sprintf("kw_token:%s:", name)
+	for _, k := range global.db.ListKeys(token_table) {
+		if strings.HasPrefix(k, prefix) {
+			global.db.Unset(token_table, k)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"github.com/cmcoffee/go-nfo"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// How often progress is written when stderr is not a terminal.
+const PROGRESS_INTERVAL = time.Second * 30
+
+// Progress of the bulk run.
+var run_progress struct {
+	total   stats_record // Users to process, 0 if unknown.
+	prior   stats_record // Users finished by an earlier attempt of a resumed run.
+	users   stats_record // Users processed.
+	folders stats_record // Folders processed.
+	started int64        // Unix nanoseconds processing began.
+}
+
+// Starts tracking progress, done users already finished by the run being resumed.
+func progress_start(done int) {
+	run_progress.total.Set(0)
+	run_progress.prior.Set(int64(done))
+	run_progress.users.Set(0)
+	run_progress.folders.Set(0)
+	atomic.StoreInt64(&run_progress.started, time.Now().UnixNano())
+}
+
+// Sets the number of users to process.
+func progress_total(total int) {
+	if total > 0 {
+		run_progress.total.Set(int64(total))
+	}
+}
+
+// Counts a processed user.
+func progress_user() {
+	run_progress.users.Add(1)
+}
+
+// Counts a processed folder.
+func progress_folder() {
+	run_progress.folders.Add(1)
+}
+
+// Returns true if stderr is a terminal.
+func stderr_is_tty() bool {
+	fi, err := os.Stderr.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Groups the digits of n with commas, ie.. 30000 -> 30,000.
+func group_digits(n int64) string {
+	str := fmt.Sprintf("%d", n)
+	if n < 0 {
+		return str
+	}
+	var output []string
+	for len(str) > 3 {
+		output = append([]string{str[len(str)-3:]}, output...)
+		str = str[:len(str)-3]
+	}
+	return strings.Join(append([]string{str}, output...), ",")
+}
+
+// Returns the progress line, or NONE if nothing has been processed yet.
+func progress_line() string {
+	started := atomic.LoadInt64(&run_progress.started)
+	if started == 0 {
+		return NONE
+	}
+
+	total := run_progress.total.Get()
+	prior := run_progress.prior.Get()
+	users := run_progress.users.Get()
+	folders := run_progress.folders.Get()
+	done := prior + users
+
+	elapsed := time.Since(time.Unix(0, started))
+	var rate float64
+	if secs := elapsed.Seconds(); secs > 0 {
+		rate = float64(users) / secs
+	}
+
+	var str []string
+	if total > 0 {
+		pct := float64(done) * 100 / float64(total)
+		if pct > 100 {
+			pct = 100
+		}
+		str = append(str, fmt.Sprintf("%s/%s users (%.1f%%)", group_digits(done), group_digits(total), pct))
+	} else {
+		str = append(str, fmt.Sprintf("%s users", group_digits(done)))
+	}
+	if folders > 0 {
+		str = append(str, fmt.Sprintf("%s folders", group_digits(folders)))
+	}
+	str = append(str, fmt.Sprintf("%.1f users/s", rate))
+
+	if total > 0 && rate > 0 && done < total {
+		eta := time.Duration(float64(total-done) / rate * float64(time.Second))
+		str = append(str, fmt.Sprintf("ETA %s", eta.Round(time.Second).String()))
+	} else {
+		str = append(str, fmt.Sprintf("elapsed %s", elapsed.Round(time.Second).String()))
+	}
+
+	return strings.Join(str, ", ")
+}
+
+// Displays the loader, showing progress once processing begins. Progress is written as
+// periodic lines when stderr is not a terminal.
+func run_loader() {
+	tty := stderr_is_tty()
+	last := time.Now()
+
+	for {
+		runtime_str := fmt.Sprintf("%s Running (%s)", APPNAME, time.Now().Sub(global.start_time).Round(time.Second).String())
+		for _, str := range loader {
+			if atomic.LoadInt32(&global.show_loader) == 1 && !global.snoop {
+				if tty {
+					if line := progress_line(); line != NONE {
+						nfo.Flash("%s %s %s", str, line, str)
+					} else {
+						nfo.Flash("%s %s %s", str, runtime_str, str)
+					}
+				} else if time.Since(last) >= PROGRESS_INTERVAL {
+					if line := progress_line(); line != NONE {
+						Stderr("Progress: %s\n", line)
+					}
+					last = time.Now()
+				}
+			}
+			time.Sleep(125 * time.Millisecond)
+		}
+	}
+}
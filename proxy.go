@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Proxy schemes supported by the transport.
+var proxy_schemes = []string{"http", "https", "socks5"}
+
+// Returns the configured proxy URI, or NONE for direct connections.
+func (c Config) proxy_uri() string {
+	if c.ProxyURI == no_proxy {
+		return NONE
+	}
+	return c.ProxyURI
+}
+
+// Parses and validates the proxy settings, returns nil when connecting directly.
+func (c Config) proxy_url() (*url.URL, error) {
+	uri := c.proxy_uri()
+	if uri == NONE {
+		return nil, nil
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid proxy URI '%s': %s", uri, err.Error())
+	}
+
+	valid := false
+	for _, v := range proxy_schemes {
+		if strings.ToLower(u.Scheme) == v {
+			valid = true
+		}
+	}
+	if !valid || u.Hostname() == NONE {
+		return nil, fmt.Errorf("Invalid proxy URI '%s', URI should be in format of http://proxy_server.domain.com:3128 or socks5://proxy_server.domain.com:1080.", uri)
+	}
+	if u.Port() == NONE {
+		return nil, fmt.Errorf("Invalid proxy URI '%s', a port is required.", uri)
+	}
+
+	if c.ProxyUser != NONE {
+		u.User = url.UserPassword(c.ProxyUser, c.ProxyPass)
+	}
+	return u, nil
+}
+
+// Returns the proxy function for the transport.
+func (c Config) proxy() (func(*http.Request) (*url.URL, error), error) {
+	if c.ProxyEnv {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	u, err := c.proxy_url()
+	if err != nil || u == nil {
+		return nil, err
+	}
+
+	bypass := c.ProxyBypass
+	return func(req *http.Request) (*url.URL, error) {
+		if proxy_bypassed(req.URL.Hostname(), bypass) {
+			return nil, nil
+		}
+		return u, nil
+	}, nil
+}
+
+// Returns true if host is in the comma separated bypass list, entries follow NO_PROXY conventions.
+// ie.. "internal.domain.com, .corp.domain.com, 10.0.0.0/8"
+func proxy_bypassed(host string, bypass string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+
+	for _, entry := range strings.Split(bypass, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == NONE {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		if ip != nil {
+			if e := net.ParseIP(entry); e != nil && e.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validates the bypass list.
+func validate_proxy_bypass(bypass string) error {
+	for _, entry := range strings.Split(bypass, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == NONE || entry == "*" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return fmt.Errorf("Invalid bypass entry '%s': %s", entry, err.Error())
+			}
+			continue
+		}
+		if strings.ContainsAny(entry, " \t") {
+			return fmt.Errorf("Invalid bypass entry '%s'.", entry)
+		}
+	}
+	return nil
+}
+
+// Shows proxy settings for display.
+func (c Config) show_proxy() string {
+	if c.ProxyEnv {
+		return "From environment (HTTPS_PROXY/NO_PROXY)"
+	}
+	uri := c.proxy_uri()
+	if uri == NONE {
+		return no_proxy
+	}
+	if c.ProxyUser != NONE {
+		uri = fmt.Sprintf("%s (user: %s)", uri, c.ProxyUser)
+	}
+	if c.ProxyBypass != NONE {
+		uri = fmt.Sprintf("%s, bypass: %s", uri, c.ProxyBypass)
+	}
+	return uri
+}
+
+// Tests that kiteworks can be reached with the proxy settings, any response from kiteworks is a success.
+func test_proxy(cfg Config) error {
+	if cfg.Server == NONE {
+		return fmt.Errorf("kiteworks host must be configured to test the proxy.")
+	}
+	if _, err := cfg.proxy(); err != nil {
+		return err
+	}
+
+	transport, err := new_transport(cfg)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   time.Second * 30,
+	}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Get(cfg.api_url("/"))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		return fmt.Errorf("Proxy rejected the credentials: %s", resp.Status)
+	}
+	return nil
+}
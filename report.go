@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Result of a change in the report.
+const (
+	RESULT_OK      = "ok"
+	RESULT_PLANNED = "planned"
+	RESULT_FAILED  = "failed"
+)
+
+// Change made during a task, one row of the change report.
+type report_row struct {
+	User            string `json:"user"`
+	FolderID        int    `json:"folder_id"`
+	FolderPath      string `json:"folder_path"`
+	Action          string `json:"action"`
+	OldExpiry       string `json:"previous_folder_expiry"`
+	NewExpiry       string `json:"new_folder_expiry"`
+	OldFileLifetime int    `json:"previous_file_lifetime"`
+	NewFileLifetime int    `json:"new_file_lifetime"`
+	Notifications   string `json:"notification_settings"`
+	DraftsDeleted   int    `json:"drafts_deleted"`
+	BytesPurged     int64  `json:"bytes_purged"`
+	Result          string `json:"result"`
+	Error           string `json:"error"`
+}
+
+// Column headers for csv reports.
+var report_columns = []string{
+	"user",
+	"folder_id",
+	"folder_path",
+	"action",
+	"previous_folder_expiry",
+	"new_folder_expiry",
+	"previous_file_lifetime",
+	"new_file_lifetime",
+	"notification_settings",
+	"drafts_deleted",
+	"bytes_purged",
+	"result",
+	"error",
+}
+
+// Returns row as csv fields.
+func (r report_row) fields() []string {
+	return []string{
+		r.User,
+		strconv.Itoa(r.FolderID),
+		r.FolderPath,
+		r.Action,
+		r.OldExpiry,
+		r.NewExpiry,
+		strconv.Itoa(r.OldFileLifetime),
+		strconv.Itoa(r.NewFileLifetime),
+		r.Notifications,
+		strconv.Itoa(r.DraftsDeleted),
+		strconv.FormatInt(r.BytesPurged, 10),
+		r.Result,
+		r.Error,
+	}
+}
+
+// Report of changes made by a task, written out when the run ends.
+type change_report struct {
+	filename string
+	format   string
+	mutex    sync.Mutex
+	rows     []report_row
+}
+
+// Starts a change report, the format is taken from the extension of filename.
+func new_report(filename string) (*change_report, error) {
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	if format != "csv" && format != "json" {
+		return nil, fmt.Errorf("--report %s should end with .csv or .json.", filename)
+	}
+	r := &change_report{
+		filename: filename,
+		format:   format,
+	}
+	task_defer(r.save)
+	return r, nil
+}
+
+// Adds change to the report, err is the outcome of the change.
+func report_change(row report_row, err error) {
+	r := global.report
+	if r == nil {
+		return
+	}
+	switch {
+	case err != nil:
+		row.Result = RESULT_FAILED
+		row.Error = err.Error()
+	case global.dry_run:
+		row.Result = RESULT_PLANNED
+	default:
+		row.Result = RESULT_OK
+	}
+	r.mutex.Lock()
+	r.rows = append(r.rows, row)
+	r.mutex.Unlock()
+}
+
+// Writes the report out to file.
+func (r *change_report) save() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	f, err := os.OpenFile(r.filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		Err("Unable to write report: %s", err.Error())
+		return
+	}
+	defer f.Close()
+
+	if r.format == "json" {
+		rows := r.rows
+		if rows == nil {
+			rows = []report_row{}
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(rows)
+	} else {
+		w := csv.NewWriter(f)
+		w.Write(report_columns)
+		for _, row := range r.rows {
+			w.Write(row.fields())
+		}
+		w.Flush()
+		err = w.Error()
+	}
+
+	if err != nil {
+		Err("Unable to write report: %s", err.Error())
+		return
+	}
+	Log("Report of %d changes written to %s.", len(r.rows), r.filename)
+}
@@ -2,19 +2,20 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha1"
-	"crypto/tls"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/cmcoffee/go-iotimeout"
 	"github.com/cmcoffee/go-nfo"
 	"io"
 	"io/ioutil"
-	"math/rand"
-	"net"
+	"math/big"
 	"net/http"
 	"net/url"
 	"os"
@@ -49,12 +50,42 @@ type Query map[string]interface{}
 
 type api_call struct {
 	*http.Client
+	user string
 }
 
-// kiteworks API Call Wrapper
+// Converts a request parameter value to its string form.
+func sprinter(input interface{}) string {
+	switch v := input.(type) {
+	case []string:
+		return strings.Join(v, ",")
+	case []int:
+		var output []string
+		for _, i := range v {
+			output = append(output, fmt.Sprintf("%v", i))
+		}
+		return strings.Join(output, ",")
+	default:
+		return fmt.Sprintf("%v", input)
+	}
+}
+
+// kiteworks API Call Wrapper, requests are cancelled with the run.
 func (s KWSession) Call(api_req APIRequest) (err error) {
+	return s.CallContext(request_context(), api_req)
+}
+
+// kiteworks API Call Wrapper, ctx cancels the request and any retries.
+func (s KWSession) CallContext(ctx context.Context, api_req APIRequest) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Record changes rather than sending them when in dry-run mode.
+	if global.dry_run && strings.ToUpper(api_req.Method) != "GET" {
+		return s.plan_change(api_req)
+	}
 
-	req, err := s.NewRequest(api_req.Method, api_req.Path, api_req.APIVer)
+	req, err := s.NewRequest(ctx, api_req.Method, api_req.Path, api_req.APIVer)
 	if err != nil {
 		return err
 	}
@@ -64,21 +95,6 @@ func (s KWSession) Call(api_req APIRequest) (err error) {
 		nfo.Stdout("--> METHOD: \"%s\" PATH: \"%s\"", strings.ToUpper(api_req.Method), api_req.Path)
 	}
 
-	sprinter := func(input interface{}) string {
-		switch v := input.(type) {
-		case []string:
-			return strings.Join(v, ",")
-		case []int:
-			var output []string
-			for _, i := range v {
-				output = append(output, fmt.Sprintf("%v", i))
-			}
-			return strings.Join(output, ",")
-		default:
-			return fmt.Sprintf("%v", input)
-		}
-	}
-
 	var body []byte
 
 	for _, in := range api_req.Params {
@@ -119,56 +135,65 @@ func (s KWSession) Call(api_req APIRequest) (err error) {
 
 	var resp *http.Response
 
+	policy := retry_policy_for(api_req.Method)
+
 	// Retry call on failures.
-	for i := 0; i < MAX_RETRY; i++ {
+	for i := 0; ; i++ {
 		req.Body = ioutil.NopCloser(bytes.NewReader(body))
-		client := s.NewClient()
+		client, err := s.NewClient()
+		if err != nil {
+			return err
+		}
+		start := time.Now()
 		resp, err = client.Do(req)
-		if err != nil && RestError(err, ERR_INTERNAL_SERVER_ERROR|TOKEN_ERR) {
-			if err := s.SetToken(req, RestError(err, TOKEN_ERR)); err != nil {
-				return err
-			}
-			time.Sleep(time.Second)
-			continue
-		} else if err != nil {
+		if err == nil {
+			err = DecodeJSON(resp, api_req.Output)
+		}
+		record_request(api_req.Method, api_req.Path, time.Since(start), err)
+		if err == nil {
+			return nil
+		}
+
+		if i+1 >= policy.attempts || !policy.retryable(err) {
 			return err
 		}
 
-		err = DecodeJSON(resp, api_req.Output)
-		if err != nil && RestError(err, ERR_INTERNAL_SERVER_ERROR|TOKEN_ERR) {
-			nfo.Debug("(JSON) %s -> %s: %s (%d/%d)", s, api_req.Path, err.Error(), i+1, MAX_RETRY)
-			if err := s.SetToken(req, RestError(err, TOKEN_ERR)); err != nil {
+		record_retry(api_req.Method, api_req.Path)
+
+		nfo.Debug("%s -> %s: %s (%d/%d)", s, api_req.Path, err.Error(), i+1, policy.attempts)
+
+		// Fetch a fresh token and try again right away.
+		if RestError(err, TOKEN_ERR) {
+			if err := s.SetToken(req, true); err != nil {
 				return err
 			}
-			time.Sleep(time.Second)
 			continue
-		} else {
-			break
+		}
+
+		select {
+		case <-time.After(policy.delay(i, retry_after(err))):
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
-	return
 }
 
 // New kiteworks Request.
-func (s KWSession) NewRequest(method, path string, api_ver int) (req *http.Request, err error) {
+func (s KWSession) NewRequest(ctx context.Context, method, path string, api_ver int) (req *http.Request, err error) {
 
 	// Set API Version
 	if api_ver == 0 {
 		api_ver = 11
 	}
 
-	server := global.config.Server
-
-	req, err = http.NewRequest(method, fmt.Sprintf("https://%s%s", server, path), nil)
+	req, err = http.NewRequestWithContext(ctx, method, global.config.api_url(path), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.URL.Host = server
-	req.URL.Scheme = "https"
 	req.Header.Set("X-Accellion-Version", fmt.Sprintf("%d", api_ver))
 	req.Header.Set("User-Agent", fmt.Sprintf("%s Admin Assistant/v%v - %v", APPNAME, VERSION_STRING, KWAdmin))
-	req.Header.Set("Referer", "https://"+server+"/")
+	req.Header.Set("Referer", global.config.api_url("/"))
 
 	if err := s.SetToken(req, false); err != nil {
 		return nil, err
@@ -183,11 +208,11 @@ type Auth struct {
 }
 
 // Get a kiteworks token.
-func NewToken(username string) (auth *Auth, err error) {
+func NewToken(ctx context.Context, username string) (auth *Auth, err error) {
 
-	path := fmt.Sprintf("https://%s/oauth/token", global.config.Server)
+	path := global.config.api_url("/oauth/token")
 
-	req, err := http.NewRequest(http.MethodPost, path, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -201,8 +226,11 @@ func NewToken(username string) (auth *Auth, err error) {
 	client_id := global.config.ClientID
 	signature := global.config.Signature
 
-	randomizer := rand.New(rand.NewSource(int64(time.Now().Unix())))
-	nonce := randomizer.Int() % 999999
+	n, err := rand.Int(rand.Reader, big.NewInt(999999))
+	if err != nil {
+		return nil, err
+	}
+	nonce := n.Int64()
 	timestamp := int64(time.Now().Unix())
 
 	base_string := fmt.Sprintf("%s|@@|%s|@@|%d|@@|%d", client_id, username, timestamp, nonce)
@@ -238,48 +266,37 @@ func NewToken(username string) (auth *Auth, err error) {
 
 	req.Body = ioutil.NopCloser(bytes.NewReader([]byte(postform.Encode())))
 
-	client := KWSession(username).NewClient()
-
-	resp, err := client.Do(req)
+	client, err := KWSession(username).NewClient()
 	if err != nil {
 		return nil, err
 	}
 
-	if err := DecodeJSON(resp, &auth); err != nil {
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err == nil {
+		err = DecodeJSON(resp, &auth)
+	}
+	record_request(http.MethodPost, "/oauth/token", time.Since(start), err)
+	if err != nil {
 		return nil, err
 	}
+	api_metrics.token_mints.Add(1)
 
 	auth.Expires = auth.Expires + time.Now().Unix()
 	return
 
 }
 
-// Set token for kiteworks.
+// Set token for kiteworks, clear replaces the token the request was sent with.
 func (s KWSession) SetToken(req *http.Request, clear bool) (err error) {
-	var token *Auth
-
-	id := fmt.Sprintf("kw_token:%s", s)
-
-	found := global.db.Get("tokens", id, &token)
-
-	// If we find a token, check if it's still valid within the next 5 minutes.
-	if found && token != nil {
-		if token.Expires < time.Now().Add(time.Duration(5*time.Minute)).Unix() {
-			global.db.Unset("tokens", id)
-			found = false
-		}
-	}
-
+	var stale string
 	if clear {
-		found = false
+		stale = strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
 	}
 
-	if !found {
-		token, err = NewToken(string(s))
-		if err != nil {
-			return err
-		}
-		global.db.CryptSet("tokens", id, &token)
+	token, err := get_token(req.Context(), string(s), stale)
+	if err != nil {
+		return err
 	}
 
 	if token != nil {
@@ -289,45 +306,50 @@ func (s KWSession) SetToken(req *http.Request, clear bool) (err error) {
 }
 
 // kiteworks Client
-func (s KWSession) NewClient() *api_call {
-	var transport http.Transport
-
-	if !global.config.SSLVerify {
-		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	}
-
-	if proxy_host := global.config.ProxyURI; proxy_host != NONE {
-		proxyURL, err := url.Parse(proxy_host)
-		errchk(err)
-		transport.Proxy = http.ProxyURL(proxyURL)
+func (s KWSession) NewClient() (*api_call, error) {
+	transport, err := api_transport()
+	if err != nil {
+		return nil, err
 	}
-
-	transport.Dial = (&net.Dialer{
-		Timeout: time.Second * 10,
-	}).Dial
-
-	transport.TLSHandshakeTimeout = time.Second * 10
-
-	return &api_call{Client: &http.Client{Transport: &transport, Timeout: 0}}
+	return &api_call{Client: &http.Client{Transport: transport, Timeout: 0}, user: string(s)}, nil
 }
 
 func (c *api_call) Do(req *http.Request) (resp *http.Response, err error) {
-	<-api_call_bank
-	defer func() { api_call_bank <- struct{}{} }()
+	// The request's context is the run's request context, so an abort doesn't wait out the rate limit.
+	if err := api_call_bank.Take(req.Context()); err != nil {
+		return nil, err
+	}
+	defer api_call_bank.Return()
 
-	resp, err = c.Client.Do(req)
+	resp, err = c.Client.Do(trace_conn(req))
 	if err != nil {
 		return nil, err
 	}
 
 	err = respError(resp)
+	var e *APIError
+	if errors.As(err, &e) {
+		e.User = c.user
+	}
+	if RestError(err, THROTTLE_ERR) {
+		api_call_bank.Throttle()
+	} else if err == nil {
+		api_call_bank.Relax()
+	}
+	if err != nil {
+		resp.Body.Close()
+	}
 	return
 }
 
 // Decodes JSON response body to provided interface.
 func DecodeJSON(resp *http.Response, output interface{}) (err error) {
 
-	defer resp.Body.Close()
+	// Drain what's left of the body so the connection can be reused.
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
 
 	var (
 		snoop_output map[string]interface{}
@@ -369,10 +391,10 @@ func DecodeJSON(resp *http.Response, output interface{}) (err error) {
 			if err := snoop_request(&snoop_buffer); err != nil {
 				nfo.Stdout(txt)
 			}
-			err = fmt.Errorf("I cannot understand what %s is saying: %s", resp.Request.Host, err.Error())
+			err = fmt.Errorf("I cannot understand what %s is saying: %w", resp.Request.Host, err)
 			return
 		} else {
-			err = fmt.Errorf("I cannot understand what %s is saying. (Try running %s --snoop): %s", resp.Request.Host, os.Args[0], err.Error())
+			err = fmt.Errorf("I cannot understand what %s is saying. (Try running %s --snoop): %w", resp.Request.Host, os.Args[0], err)
 			return
 		}
 	}
@@ -449,24 +471,58 @@ func respError(resp *http.Response) (err error) {
 		return err
 	}
 
+	// Appliance is overloaded or rate limiting us.
+	overloaded := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+
 	var kite_err *KiteErr
 	json.Unmarshal(output, &kite_err)
 	if kite_err != nil {
-		e := NewRestError()
+		e := new_resp_error(resp)
 		for _, v := range kite_err.Errors {
 			e.AddKWError(v.Code, v.Message)
 		}
 		if kite_err.ErrorDesc != NONE {
 			e.AddKWError(kite_err.Error, kite_err.ErrorDesc)
 		}
+		if overloaded && !RestError(e, THROTTLE_ERR) {
+			e.AddKWError("SERVICE_UNAVAILABLE", fmt.Sprintf("%s says \"%s\"", resp.Request.Host, resp.Status))
+		}
+		e.retry_after = read_retry_after(resp)
 		return e
 	}
 
-	if resp.Status == "401 Unathorized" {
-		e := NewRestError()
+	if overloaded {
+		e := new_resp_error(resp)
+		e.AddKWError("SERVICE_UNAVAILABLE", fmt.Sprintf("%s says \"%s\"", resp.Request.Host, resp.Status))
+		e.retry_after = read_retry_after(resp)
+		return e
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		e := new_resp_error(resp)
 		e.AddKWError("ERR_AUTH_UNAUTHORIZED", "Unathorized Access Token")
 		return e
 	}
 
-	return fmt.Errorf("%s says \"%s.\"", resp.Request.Host, resp.Status)
+	// Not a kiteworks error, ie.. an error page from a proxy in front of the appliance.
+	e := new_resp_error(resp)
+	e.flag = ERR_UNKNOWN
+	msg := fmt.Sprintf("%s says \"%s.\"", resp.Request.Host, resp.Status)
+	if snippet := body_snippet(output); snippet != NONE {
+		msg = fmt.Sprintf("%s: %s", msg, snippet)
+	}
+	e.message = append(e.message, msg)
+	return e
+}
+
+// Most of a response body shown in an error.
+const MAX_BODY_SNIPPET = 200
+
+// Returns the start of body on a single line, for showing in an error.
+func body_snippet(body []byte) string {
+	snippet := []rune(strings.Join(strings.Fields(string(body)), " "))
+	if len(snippet) > MAX_BODY_SNIPPET {
+		return string(snippet[:MAX_BODY_SNIPPET]) + "..."
+	}
+	return string(snippet)
 }
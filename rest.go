@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strconv"
 	"strings"
 	"time"
 )
@@ -73,54 +74,69 @@ type FolderPermission struct {
 	Disabled   bool   `json:"disabled"`
 }
 
-// List Folders.
-func (s KWSession) ListFolders(folder_id int) (output []KiteFolder, err error) {
-
-	var KiteArray struct {
-		Folders []KiteFolder `json:"data"`
-	}
-
-	req := APIRequest{
+// Request for listing the folders nested in folder_id.
+func list_folders_req(folder_id int) APIRequest {
+	return APIRequest{
 		APIVer: 7,
 		Method: "GET",
 		Path:   SetPath("/rest/folders/%d/folders", folder_id),
 		Params: SetParams(Query{"deleted": false}),
-		Output: &KiteArray,
 	}
-	return KiteArray.Folders, s.Call(req)
 }
 
-// List Files.
-func (s KWSession) ListFiles(folder_id int) ([]KiteFile, error) {
-
-	var KiteArray struct {
-		Files []KiteFile `json:"data"`
+// Request for listing all top level folders.
+func top_folders_req() APIRequest {
+	return APIRequest{
+		Method: "GET",
+		Path:   "/rest/folders/top",
+		Params: SetParams(Query{"deleted": false}),
 	}
+}
+
+// List Folders.
+func (s KWSession) ListFolders(folder_id int) (output []KiteFolder, err error) {
+	err = s.DataCall(list_folders_req(folder_id), &output)
+	return
+}
+
+// List Files.
+func (s KWSession) ListFiles(folder_id int) (output []KiteFile, err error) {
 
 	req := APIRequest{
 		APIVer: 5,
 		Method: "GET",
 		Path:   SetPath("/rest/folders/%d/files", folder_id),
 		Params: SetParams(Query{"deleted": false}),
-		Output: &KiteArray,
 	}
-	return KiteArray.Files, s.Call(req)
+	err = s.DataCall(req, &output)
+	return
 }
 
 // Pulls up all top level folders.
-func (s KWSession) GetFolders() ([]KiteFolder, error) {
-
-	var KiteArray struct {
-		Folders []KiteFolder `json:"data"`
-	}
+func (s KWSession) GetFolders() (output []KiteFolder, err error) {
+	err = s.DataCall(top_folders_req(), &output)
+	return
+}
 
-	req := APIRequest{
-		Method: "GET",
-		Path:   "/rest/folders/top",
-		Params: SetParams(Query{"deleted": false}),
-		Output: &KiteArray,
+// Pages through a folder listing looking for name, stops paging once found.
+func (s KWSession) find_folder_named(api_req APIRequest, name string) (folder KiteFolder, err error) {
+	pager := s.NewPager(api_req, PAGE_SIZE)
+	for {
+		var folders []KiteFolder
+		more, err := pager.Next(&folders)
+		if err != nil {
+			return folder, err
+		}
+		if !more {
+			return folder, ErrNotFound
+		}
+		for _, f := range folders {
+			if strings.ToLower(f.Name) == strings.ToLower(name) {
+				pager.Stop()
+				return f, nil
+			}
+		}
 	}
-	return KiteArray.Folders, s.Call(req)
 }
 
 // Set expiries on folder.
@@ -168,8 +184,6 @@ func (s KWSession) FolderInfo(folder_id int) (output KiteFolder, err error) {
 // Returns the folder id of folder, can be specified as TopFolder/Nested or TopFolder\Nested.
 func (s KWSession) FindFolder(remote_folder string) (folder KiteFolder, err error) {
 
-	id := -1
-
 	base_folder_id, err := s.MyBaseDirID()
 	if err != nil {
 		return
@@ -188,55 +202,63 @@ func (s KWSession) FindFolder(remote_folder string) (folder KiteFolder, err erro
 		return false
 	}
 
-	var folders []KiteFolder
-
 	if base_folder_id < 1 {
-		folders, err = s.GetFolders()
-		if err != nil {
-			return
-		}
+		folder, err = s.find_folder_named(top_folders_req(), folder_names[0])
 	} else {
-		folders, err = s.ListFolders(base_folder_id)
-		if err != nil {
-			return
-		}
+		folder, err = s.find_folder_named(list_folders_req(base_folder_id), folder_names[0])
 	}
-
-	for _, e := range folders {
-		if strings.ToLower(e.Name) == strings.ToLower(folder_names[0]) {
-			id = e.ID
-			folder = e
-			break
-		}
-	}
-
-	if id < 0 {
-		err = ErrNotFound
+	if err != nil {
 		return
 	}
 
 	for shift_name() {
-		found := false
-		nested, err := s.ListFolders(id)
+		folder, err = s.find_folder_named(list_folders_req(folder.ID), folder_names[0])
 		if err != nil {
 			break
 		}
+	}
 
-		for _, elem := range nested {
-			if strings.ToLower(elem.Name) == strings.ToLower(folder_names[0]) {
-				folder = elem
-				id = elem.ID
-				found = true
-				break
-			}
-		}
+	return
+}
 
-		if !found {
-			err = ErrNotFound
-			break
-		}
+// Folder notification settings.
+type KiteNotifications struct {
+	FileAdded    bool
+	CommentAdded bool
+}
+
+// Reads a kiteworks flag, which may be sent as a bool, number or string.
+func kw_bool(input interface{}) bool {
+	switch v := input.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return false
+}
+
+// Returns the user's notification settings for folder.
+func (s KWSession) GetNotifications(folder_id int) (output KiteNotifications, err error) {
+	var n struct {
+		FileAdded    interface{} `json:"fileAdded"`
+		CommentAdded interface{} `json:"commentAdded"`
+	}
+
+	err = s.Call(APIRequest{
+		Method: "GET",
+		Path:   SetPath("/rest/folders/%d/notifications", folder_id),
+		Output: &n,
+	})
+	if err != nil {
+		return
 	}
 
+	output.FileAdded = kw_bool(n.FileAdded)
+	output.CommentAdded = kw_bool(n.CommentAdded)
 	return
 }
 
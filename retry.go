@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Longest we'll honor a server's Retry-After.
+const max_retry_after = time.Duration(5 * time.Minute)
+
+// Retry policy for a class of request.
+type retry_policy struct {
+	attempts  int           // Total attempts, including the first.
+	base      time.Duration // Delay before the first retry, doubled on each retry after.
+	max       time.Duration // Longest delay between attempts.
+	net_retry bool          // Retry network failures that may have reached the server.
+}
+
+// Retry policies by request method, idempotent requests are retried more aggressively.
+var retry_policies = map[string]retry_policy{
+	http.MethodGet:    {attempts: 6, base: time.Second, max: time.Second * 30, net_retry: true},
+	http.MethodPut:    {attempts: 4, base: time.Second, max: time.Second * 20, net_retry: true},
+	http.MethodPost:   {attempts: MAX_RETRY, base: time.Second * 2, max: time.Second * 20, net_retry: false},
+	http.MethodDelete: {attempts: MAX_RETRY, base: time.Second * 2, max: time.Second * 15, net_retry: false},
+}
+
+// Returns retry policy for method.
+func retry_policy_for(method string) retry_policy {
+	if p, ok := retry_policies[strings.ToUpper(method)]; ok {
+		return p
+	}
+	return retry_policies[http.MethodPost]
+}
+
+// Delay before retry number attempt, exponential with jitter, and no shorter than a server's Retry-After.
+func (p retry_policy) delay(attempt int, retry_after time.Duration) time.Duration {
+	d := p.max
+	if attempt < 16 {
+		if exp := p.base << uint(attempt); exp > 0 && exp < p.max {
+			d = exp
+		}
+	}
+
+	// Keep half the delay, jitter the other half so concurrent workers don't retry in lock-step.
+	d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+
+	if retry_after > max_retry_after {
+		retry_after = max_retry_after
+	}
+	if retry_after > d {
+		d = retry_after
+	}
+	return d
+}
+
+// Returns true if err should be retried under policy.
+func (p retry_policy) retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if RestError(err, ERR_INTERNAL_SERVER_ERROR|TOKEN_ERR|THROTTLE_ERR) {
+		return true
+	}
+	if IsRestError(err) {
+		return false
+	}
+	// Failed to connect, request never reached the server.
+	if dial_error(err) {
+		return true
+	}
+	return p.net_retry && transient_error(err)
+}
+
+// Returns true if err happened while connecting to the server.
+func dial_error(err error) bool {
+	var op_err *net.OpError
+	if errors.As(err, &op_err) && op_err.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// Returns true if err is a network failure likely to clear up on its own.
+func transient_error(err error) bool {
+	var net_err net.Error
+	if errors.As(err, &net_err) && net_err.Timeout() {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	// Read stalls from iotimeout and wrapped errors that have lost their type.
+	msg := strings.ToLower(err.Error())
+	for _, v := range []string{"timeout", "timed out", "connection reset", "broken pipe", "unexpected eof"} {
+		if strings.Contains(msg, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads the Retry-After header, which is either delay seconds or an HTTP date.
+func read_retry_after(resp *http.Response) time.Duration {
+	val := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if val == NONE {
+		return 0
+	}
+	if secs, err := strconv.Atoi(val); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(val); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// Returns the Retry-After requested by the server for err.
+func retry_after(err error) time.Duration {
+	var e *APIError
+	if errors.As(err, &e) {
+		return e.retry_after
+	}
+	return 0
+}
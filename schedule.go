@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tables holding the schedule and the outcome of scheduled runs.
+const (
+	schedule_table         = "schedule"
+	schedule_history_table = "schedule_history"
+)
+
+// Most outcomes kept in the schedule history.
+const MAX_SCHEDULE_HISTORY = 1000
+
+// Outcome of a scheduled run that was skipped because the previous run was still going.
+const RUN_SKIPPED = "skipped"
+
+// Tasks that can't be scheduled.
+var unschedulable = []string{"daemon", "schedule", "shell"}
+
+// Scheduled task invocation.
+type schedule_entry struct {
+	ID       string   `json:"id"`
+	Cron     string   `json:"cron"`
+	Args     []string `json:"args"`
+	Profile  string   `json:"profile"`
+	Added    string   `json:"added"`
+	LastRun  string   `json:"last_run"`
+	Status   string   `json:"status"`
+	Errors   int64    `json:"errors"`
+	RunID    string   `json:"run_id"`
+	Duration string   `json:"duration"`
+	spec     *cron_spec
+}
+
+// Outcome of a scheduled run.
+type schedule_outcome struct {
+	Entry    string   `json:"entry"`
+	Args     []string `json:"args"`
+	Profile  string   `json:"profile"`
+	Started  string   `json:"started"`
+	Duration string   `json:"duration"`
+	Status   string   `json:"status"`
+	Errors   int64    `json:"errors"`
+	RunID    string   `json:"run_id"`
+	Message  string   `json:"message,omitempty"`
+}
+
+// Entry of a schedule file.
+type schedule_file_entry struct {
+	Cron string `json:"cron" yaml:"cron"`
+	Task string `json:"task" yaml:"task"`
+}
+
+// Returns a new schedule entry for args, checking the schedule and task are valid.
+func new_schedule_entry(id, cron string, args []string) (*schedule_entry, error) {
+	spec, err := parse_cron(cron)
+	if err != nil {
+		return nil, err
+	}
+	if spec.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("Schedule '%s' never comes due, check the day of month fits the month.", cron)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("Schedule '%s' has no task to run.", cron)
+	}
+	for _, name := range unschedulable {
+		if args[0] == name {
+			return nil, fmt.Errorf("The '%s' command can't be scheduled.", name)
+		}
+	}
+	var found bool
+	for _, name := range global.menu.Names() {
+		if args[0] == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("No such task: '%s' found.", args[0])
+	}
+	return &schedule_entry{
+		ID:      id,
+		Cron:    spec.expr,
+		Args:    args,
+		Profile: global.profile,
+		Added:   time.Now().UTC().Format(time.RFC3339),
+		spec:    spec,
+	}, nil
+}
+
+// Returns the command line of the entry.
+func (s *schedule_entry) command() string {
+	return join_args(s.Args)
+}
+
+// Returns the schedule entries saved in kitetool.db, ordered by id.
+func saved_schedule() (entries []*schedule_entry) {
+	for _, k := range global.db.ListKeys(schedule_table) {
+		entry := new(schedule_entry)
+		if !global.db.Get(schedule_table, k, entry) {
+			continue
+		}
+		spec, err := parse_cron(entry.Cron)
+		if err != nil {
+			Err("Schedule entry %s: %s", entry.ID, err.Error())
+			continue
+		}
+		entry.spec = spec
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, _ := strconv.Atoi(entries[i].ID)
+		b, _ := strconv.Atoi(entries[j].ID)
+		return a < b
+	})
+	return
+}
+
+// Returns the schedule entry with id, or nil if there isn't one.
+func get_schedule(id string) *schedule_entry {
+	for _, entry := range saved_schedule() {
+		if entry.ID == id {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Adds a schedule entry to kitetool.db.
+func add_schedule(cron string, args []string) (*schedule_entry, error) {
+	var id int
+	for _, k := range global.db.ListKeys(schedule_table) {
+		if n, err := strconv.Atoi(k); err == nil && n > id {
+			id = n
+		}
+	}
+	entry, err := new_schedule_entry(strconv.Itoa(id+1), cron, args)
+	if err != nil {
+		return nil, err
+	}
+	global.db.Set(schedule_table, entry.ID, entry)
+	return entry, nil
+}
+
+// Removes the schedule entry with id from kitetool.db.
+func remove_schedule(id string) error {
+	if get_schedule(id) == nil {
+		return fmt.Errorf("No schedule entry with id '%s', see schedule list.", id)
+	}
+	global.db.Unset(schedule_table, id)
+	return nil
+}
+
+// Reads schedule entries from a json or yaml file.
+func load_schedule_file(filename string) (entries []*schedule_entry, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var input []schedule_file_entry
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &input)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&input)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s: %s", filename, err.Error())
+	}
+
+	for i, v := range input {
+		args, err := shell_split(v.Task)
+		if err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %s", filename, i+1, err.Error())
+		}
+		entry, err := new_schedule_entry(fmt.Sprintf("file-%d", i+1), v.Cron, args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %s", filename, i+1, err.Error())
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Records the outcome of a scheduled run, saving the last outcome with entries kept in kitetool.db.
+func record_outcome(entry *schedule_entry, outcome schedule_outcome) {
+	entry.LastRun = outcome.Started
+	entry.Status = outcome.Status
+	entry.Errors = outcome.Errors
+	entry.RunID = outcome.RunID
+	entry.Duration = outcome.Duration
+
+	var saved schedule_entry
+	if global.db.Get(schedule_table, entry.ID, &saved) {
+		saved.LastRun, saved.Status, saved.Errors, saved.RunID, saved.Duration = entry.LastRun, entry.Status, entry.Errors, entry.RunID, entry.Duration
+		global.db.Set(schedule_table, entry.ID, &saved)
+	}
+	save_outcome(outcome)
+}
+
+// Adds outcome to the schedule history, dropping the oldest outcomes once full.
+func save_outcome(outcome schedule_outcome) {
+	global.db.Set(schedule_history_table, fmt.Sprintf("%s %s", time.Now().UTC().Format("20060102-150405.000000000"), outcome.Entry), &outcome)
+
+	keys := global.db.ListKeys(schedule_history_table)
+	if len(keys) > MAX_SCHEDULE_HISTORY {
+		sort.Strings(keys)
+		for _, k := range keys[:len(keys)-MAX_SCHEDULE_HISTORY] {
+			global.db.Unset(schedule_history_table, k)
+		}
+	}
+}
+
+// Returns the outcomes of scheduled runs, oldest first, only those of entry id if given.
+func schedule_history(id string) (outcomes []schedule_outcome) {
+	keys := global.db.ListKeys(schedule_history_table)
+	sort.Strings(keys)
+	for _, k := range keys {
+		var outcome schedule_outcome
+		if !global.db.Get(schedule_history_table, k, &outcome) {
+			continue
+		}
+		if id != NONE && outcome.Entry != id {
+			continue
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	return
+}
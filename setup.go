@@ -3,6 +3,10 @@ package main
 import (
 	"fmt"
 	"github.com/cmcoffee/go-nfo"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -14,8 +18,22 @@ type Config struct {
 	Signature    string `json:"signature"`
 	Tested       bool   `json:"tested"`
 	SSLVerify    bool   `json:"verify_ssl"`
+	CABundle     string `json:"ca_bundle"`
+	ClientCert   string `json:"client_cert"`
+	ClientKey    string `json:"client_key"`
+	PinnedKeys   string `json:"pinned_keys"`
 	ProxyURI     string `json:"proxy_uri"`
+	ProxyUser    string `json:"proxy_user"`
+	ProxyPass    string `json:"proxy_password"`
+	ProxyBypass  string `json:"proxy_bypass"`
+	ProxyEnv     bool   `json:"proxy_from_env"`
 	RedirectURI  string `json:"redirect_uri"`
+	Threads      int    `json:"threads"`
+	RateLimit    int    `json:"rate_limit"`
+	PlainHTTP    bool   `json:"plain_http"`
+	Port         int    `json:"port"`
+	BasePath     string `json:"base_path"`
+	Resolve      string `json:"resolve"`
 }
 
 const (
@@ -30,38 +48,307 @@ func (c Config) configured() bool {
 	return true
 }
 
+// Scheme used to reach kiteworks.
+func (c Config) scheme() string {
+	if c.PlainHTTP {
+		return "http"
+	}
+	return "https"
+}
+
+// Host used to reach kiteworks, with the port when it isn't the scheme's default.
+func (c Config) host() string {
+	if c.Port <= 0 || (c.Port == 443 && !c.PlainHTTP) || (c.Port == 80 && c.PlainHTTP) {
+		return c.Server
+	}
+	return net.JoinHostPort(c.Server, strconv.Itoa(c.Port))
+}
+
+// Path kiteworks is served under when behind a reverse proxy, ie.. /kiteworks.
+func (c Config) base_path() string {
+	path := strings.Trim(strings.TrimSpace(c.BasePath), "/")
+	if path == NONE {
+		return NONE
+	}
+	return "/" + path
+}
+
+// Returns the URL of path on kiteworks.
+func (c Config) api_url(path string) string {
+	return fmt.Sprintf("%s://%s%s%s", c.scheme(), c.host(), c.base_path(), path)
+}
+
+// Parses the host resolution override, in the format of host:ip.
+func (c Config) resolve() (host, ip string, err error) {
+	if c.Resolve == NONE {
+		return NONE, NONE, nil
+	}
+	split := strings.SplitN(c.Resolve, ":", 2)
+	if len(split) != 2 {
+		return NONE, NONE, fmt.Errorf("Invalid resolve '%s', should be in format of host:ip. (ie.. kiteworks.domain.com:10.0.0.5)", c.Resolve)
+	}
+	host = strings.TrimSpace(split[0])
+	ip = strings.Trim(strings.TrimSpace(split[1]), "[]")
+	if host == NONE || net.ParseIP(ip) == nil {
+		return NONE, NONE, fmt.Errorf("Invalid resolve '%s', should be in format of host:ip. (ie.. kiteworks.domain.com:10.0.0.5)", c.Resolve)
+	}
+	return host, ip, nil
+}
+
+// Returns an error if the host resolution override would go through a proxy, which resolves the host itself.
+func (c Config) check_resolve() error {
+	host, _, err := c.resolve()
+	if err != nil || host == NONE {
+		return err
+	}
+	proxy, err := c.proxy()
+	if err != nil || proxy == nil {
+		return err
+	}
+	target, err := url.Parse(c.api_url("/"))
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(target.Hostname(), host) {
+		return nil
+	}
+	if u, _ := proxy(&http.Request{URL: target}); u != nil {
+		return fmt.Errorf("Resolve '%s' can't be used with proxy %s, connections to %s go through the proxy. Add %s to the proxy bypass list or remove the resolve setting.", c.Resolve, u.Redacted(), host, host)
+	}
+	return nil
+}
+
+// Shows connection settings for display.
+func (c Config) show_connection() string {
+	port := c.Port
+	if port <= 0 {
+		if c.PlainHTTP {
+			port = 80
+		} else {
+			port = 443
+		}
+	}
+	str := fmt.Sprintf("%s, port %d", strings.ToUpper(c.scheme()), port)
+	if path := c.base_path(); path != NONE {
+		str = fmt.Sprintf("%s, path %s", str, path)
+	}
+	if host, ip, err := c.resolve(); err == nil && host != NONE {
+		str = fmt.Sprintf("%s, %s resolves to %s", str, host, ip)
+	}
+	return str
+}
+
+// Configuration for scheme and port.
+func (c *Config) setup_connection() {
+	for {
+		toggle := "Use plain HTTP. (Testing only, credentials are sent unencrypted!)"
+		if c.PlainHTTP {
+			toggle = "Use HTTPS."
+		}
+
+		in := nfo.NeedAnswer(fmt.Sprintf(`
+--- Connection Configuration, Current Setting: %s
+
+    [1] Set port.
+    [2] %s
+    [3] Set base path.
+    [4] Set host resolution override.
+
+(selection or 'b' to go back): `, c.show_connection(), toggle), nfo.Input)
+
+		switch in {
+		case "1":
+			in := nfo.Input(`
+# Port kiteworks listens on, blank for the default port.
+--> Port: `)
+			if in == NONE {
+				c.Port = 0
+				c.Tested = false
+				continue
+			}
+			port, err := strconv.Atoi(in)
+			if err != nil || port < 1 || port > 65535 {
+				Printf("\n*** Invalid port '%s', port should be between 1 and 65535.\n", in)
+				continue
+			}
+			c.Port = port
+			c.Tested = false
+		case "2":
+			c.PlainHTTP = !c.PlainHTTP
+			c.Tested = false
+		case "3":
+			c.BasePath = nfo.Input(`
+# Path kiteworks is served under when behind a reverse proxy, blank when served from the root. (ie.. /kiteworks)
+--> Base Path: `)
+			c.BasePath = c.base_path()
+			c.Tested = false
+		case "4":
+			resolve := nfo.Input(`
+# Connect to a specific node while keeping the kiteworks hostname for TLS, blank to use DNS. (ie.. kiteworks.domain.com:10.0.0.5)
+--> Resolve: `)
+			prior := c.Resolve
+			c.Resolve = resolve
+			if _, _, err := c.resolve(); err != nil {
+				Printf("\n*** %s\n", err.Error())
+				c.Resolve = prior
+				continue
+			}
+			c.Tested = false
+		case "b":
+			return
+		default:
+			Printf("\n*** Invalid option '%s', please try again.\n", in)
+		}
+	}
+}
+
 // Configuration for proxy settings.
 func (c *Config) setup_proxy() {
+	// Validates and optionally tests changes, reverting them when invalid.
+	apply := func(prior Config) {
+		if _, err := c.proxy(); err != nil {
+			Printf("\n*** %s\n", err.Error())
+			*c = prior
+			return
+		}
+		c.Tested = false
+		if c.Server != NONE && nfo.Confirm("\nWould you like to test the proxy settings?") {
+			if err := test_proxy(*c); err != nil {
+				Printf("\n*** Proxy test failed: %s\n", err.Error())
+			} else {
+				Printf("\nkiteworks was reached successfully.\n")
+			}
+		}
+	}
+
 	for {
+		env_toggle := "Use proxy settings from environment. (HTTPS_PROXY/NO_PROXY)"
+		if c.ProxyEnv {
+			env_toggle = "Stop using proxy settings from environment."
+		}
+
 		in := nfo.NeedAnswer(fmt.Sprintf(`
 --- Proxy Configuration, Current Setting: %s
 
     [1] Set Proxy URI.
-    [2] Disable Proxy, Use Direct Connections.
+    [2] Set Proxy Credentials.
+    [3] Set Proxy Bypass List.
+    [4] %s
+    [5] Disable Proxy, Use Direct Connections.
+    [6] Test Proxy Settings.
+
+(selection or 'b' to go back): `, c.show_proxy(), env_toggle), nfo.Input)
 
-(selection or 'b' to go back): `, c.ProxyURI), nfo.Input)
+		prior := *c
 
 		switch in {
 		case "1":
 			proxy := nfo.Input(`
-# Proxy URI is typically in format of http://proxy_server.domain.com:3128
+# Proxy URI is typically in format of http://proxy_server.domain.com:3128 or socks5://proxy_server.domain.com:1080
 --> Proxy URI: `)
 			if proxy == NONE {
 				c.ProxyURI = no_proxy
 				c.Tested = false
 				continue
 			}
-			if !strings.Contains(proxy, "http") {
-				Printf("\n*** Invalid URI '%s', URI should be in format of http://proxy_server.domain.com:3128.", proxy)
+			c.ProxyURI = proxy
+			c.ProxyEnv = false
+			apply(prior)
+		case "2":
+			c.ProxyUser = nfo.Input(`
+# Leave blank if the proxy does not require authentication.
+--> Proxy Username: `)
+			if c.ProxyUser == NONE {
+				c.ProxyPass = NONE
+			} else {
+				c.ProxyPass = nfo.Secret("--> Proxy Password: ")
+			}
+			apply(prior)
+		case "3":
+			bypass := nfo.Input(`
+# Comma separated hosts, domains or networks to connect to directly. (ie.. kiteworks.domain.com, .internal.domain.com, 10.0.0.0/8)
+--> Proxy Bypass: `)
+			if err := validate_proxy_bypass(bypass); err != nil {
+				Printf("\n*** %s\n", err.Error())
 				continue
+			}
+			c.ProxyBypass = bypass
+			apply(prior)
+		case "4":
+			c.ProxyEnv = !c.ProxyEnv
+			apply(prior)
+		case "5":
+			c.ProxyURI = no_proxy
+			c.ProxyEnv = false
+			c.Tested = false
+			return
+		case "6":
+			if err := test_proxy(*c); err != nil {
+				Printf("\n*** Proxy test failed: %s\n", err.Error())
 			} else {
-				c.ProxyURI = proxy
-				c.Tested = false
+				Printf("\nkiteworks was reached successfully.\n")
 			}
+		case "b":
 			return
+		default:
+			Printf("\n*** Invalid option '%s', please try again.\n", in)
+		}
+	}
+}
+
+// Shows rate limit for display.
+func (c Config) show_rate_limit() string {
+	if c.RateLimit <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d/sec", c.RateLimit)
+}
+
+// Shows concurrent API requests for display.
+func (c Config) show_threads() int {
+	if c.Threads <= 0 {
+		return MAX_CONNECTIONS
+	}
+	return c.Threads
+}
+
+// Configuration for concurrent requests and rate limiting.
+func (c *Config) setup_throttle() {
+	read_int := func(prompt string) (int, bool) {
+		in := nfo.Input(prompt)
+		if in == NONE {
+			return 0, true
+		}
+		num, err := strconv.Atoi(in)
+		if err != nil || num < 0 {
+			Printf("\n*** Invalid number '%s', please try again.\n", in)
+			return 0, false
+		}
+		return num, true
+	}
+
+	for {
+		in := nfo.NeedAnswer(fmt.Sprintf(`
+--- API Throttling, Current Setting: %d concurrent requests, %s
+
+    [1] Set concurrent API requests.
+    [2] Set API requests per second.
+
+(selection or 'b' to go back): `, c.show_threads(), c.show_rate_limit()), nfo.Input)
+
+		switch in {
+		case "1":
+			if num, ok := read_int(fmt.Sprintf(`
+# Number of API requests sent to kiteworks at once, blank for default of %d.
+--> Concurrent API requests: `, MAX_CONNECTIONS)); ok {
+				c.Threads = num
+			}
 		case "2":
-			c.ProxyURI = no_proxy
-			return
+			if num, ok := read_int(`
+# Maximum API requests sent to kiteworks per second, 0 or blank for unlimited.
+--> API requests per second: `); ok {
+				c.RateLimit = num
+			}
 		case "b":
 			return
 		default:
@@ -84,18 +371,27 @@ func test_api() (err error) {
 
 // Perform setup of system.
 func setup(setup_requested bool) {
-	cfg := &global.config
+	if global.config.configured() && !setup_requested {
+		if global.config.ProxyURI == no_proxy {
+			global.config.ProxyURI = NONE
+		}
+		KWAdmin = KWSession(global.config.Admin)
+		return
+	}
+
+	// Only the saved settings are edited and saved, overrides are applied on top of them when tested.
+	saved, _ := saved_config()
+	cfg := &saved
 
 	if !cfg.configured() {
 		cfg.SSLVerify = true
 		cfg.ProxyURI = no_proxy
 		cfg.RedirectURI = fmt.Sprintf("https://%s/", APPNAME)
-	} else if !setup_requested {
-		if cfg.ProxyURI == no_proxy {
-			cfg.ProxyURI = NONE
-		}
-		KWAdmin = KWSession(cfg.Admin)
-		return
+	}
+
+	apply := func() error {
+		global.config = *cfg
+		return global.config.apply_overrides()
 	}
 
 	Defer(func() { Printf("\n") })
@@ -118,7 +414,7 @@ func setup(setup_requested bool) {
 
 	for {
 		in := nfo.NeedAnswer(fmt.Sprintf(`
---- %s Configuration ---
+--- %s Configuration, Profile: %s ---
 
   [1] kiteworks Host:   %s
   [2] Client App ID:    %s
@@ -126,11 +422,14 @@ func setup(setup_requested bool) {
   [4] Signature Secret: %s
   [5] SysAdmin Account: %s
   [6] Redirect URI:     %s
-  [7] Verify SSL:       %v
+  [7] TLS Settings:     %s
   [8] Proxy Server:     %s
+  [9] API Throttling:   %d concurrent requests, %s
+  [10] Connection:      %s
 
-(selection or 'q' to save & exit): `, APPNAME, show_var(cfg.Server), show_var(cfg.ClientID), show_var(hide_var(cfg.ClientSecret)),
-			show_var(hide_var(cfg.Signature)), show_var(cfg.Admin), show_var(cfg.RedirectURI), cfg.SSLVerify, cfg.ProxyURI), nfo.Input)
+(selection or 'q' to save & exit): `, APPNAME, global.profile, show_var(cfg.Server), show_var(cfg.ClientID), show_var(hide_var(cfg.ClientSecret)),
+			show_var(hide_var(cfg.Signature)), show_var(cfg.Admin), show_var(cfg.RedirectURI), cfg.show_tls(), cfg.show_proxy(),
+			cfg.show_threads(), cfg.show_rate_limit(), cfg.show_connection()), nfo.Input)
 		switch in {
 		case "1":
 			cfg.Server = nfo.NeedAnswer(`
@@ -159,36 +458,40 @@ func setup(setup_requested bool) {
 # This simply needs to match the API configuration in kiteworks.
 --> Redirect URI: `, nfo.Input)
 		case "7":
-			if cfg.SSLVerify {
-				cfg.SSLVerify = false
-			} else {
-				cfg.SSLVerify = true
-			}
+			cfg.setup_tls()
 		case "8":
 			cfg.setup_proxy()
+		case "9":
+			cfg.setup_throttle()
+		case "10":
+			cfg.setup_connection()
 		case "q":
 			if !cfg.configured() {
-				global.db.CryptSet(APPNAME, "config", &cfg)
+				save_config(cfg)
 				Exit(0)
 			}
 			if !cfg.Tested {
 				if nfo.Confirm("\nWould you like validate settings with a quick test?") {
-					if err := test_api(); err != nil {
+					err := apply()
+					if err == nil {
+						err = test_api()
+					}
+					if err != nil {
 						Err(err)
 						continue
 					} else {
 						Printf("API Tested Successfully! Configuration has been updated.")
 						cfg.Tested = true
-						global.db.CryptSet(APPNAME, "config", &cfg)
+						save_config(cfg)
 						Exit(0)
 					}
 				} else {
 					cfg.Tested = true
-					global.db.CryptSet(APPNAME, "config", &cfg)
+					save_config(cfg)
 					Exit(0)
 				}
 			}
-			global.db.CryptSet(APPNAME, "config", &cfg)
+			save_config(cfg)
 			Exit(0)
 		case "?":
 		default:
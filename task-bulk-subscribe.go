@@ -1,5 +1,9 @@
 package main
 
+import (
+	"fmt"
+)
+
 func init() {
 	global.menu.Register("folder-notifications", "Set folder and file expiries for users.", BulkSubscribe)
 }
@@ -13,22 +17,96 @@ func BulkSubscribe(flag *task) (err error) {
 		return err
 	}
 
-	my_func := func(user KiteUser) {
+	flag.LogStart()
+
+	ctx := work_context()
+
+	my_func := func(user KiteUser) error {
 		S := KWSession(user.Email)
+		var failed int
 
 		for _, f := range BulkFolders(user, *folderList) {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			if f.Name == "My Folder" {
 				continue
 			}
-			Log("[%s]: Updating notification settings for folder %s. (File Notifications: %v, Folder Notifications: %v)", string(S), f.Name, *fileAdded, *commentAdded)
-			if err = S.SetNotifications(f.ID, true, *fileAdded, *commentAdded); err != nil {
+			progress_folder()
+			event := Event{
+				User:     string(S),
+				FolderID: f.ID,
+				Path:     f.Name,
+				Action:   "set_notifications",
+				New:      map[string]bool{"file_added": *fileAdded, "comment_added": *commentAdded},
+			}
+			if !global.dry_run {
+				prior, err := S.GetNotifications(f.ID)
+				if err != nil {
+					event.Err(err, "[%s]: Unable to read notification settings for folder %s, skipping folder: %s", string(S), f.Name, err.Error())
+					failed++
+					continue
+				}
+				event.Old = map[string]bool{"file_added": prior.FileAdded, "comment_added": prior.CommentAdded}
+				// Settings are applied to nested folders too, so save each of their settings for rollback.
+				if err := journal_notifications(S, f.ID, f.Name, prior); err != nil {
+					event.Err(err, "[%s]: Unable to read notification settings within folder %s, skipping folder: %s", string(S), f.Name, err.Error())
+					failed++
+					continue
+				}
+			}
+			event.Log("[%s]: Updating notification settings for folder %s. (File Notifications: %v, Folder Notifications: %v)", string(S), f.Name, *fileAdded, *commentAdded)
+			err := S.SetNotifications(f.ID, true, *fileAdded, *commentAdded)
+			report_change(report_row{
+				User:          string(S),
+				FolderID:      f.ID,
+				FolderPath:    f.Name,
+				Action:        event.Action,
+				Notifications: fmt.Sprintf("file_added=%v comment_added=%v", *fileAdded, *commentAdded),
+			}, err)
+			if err != nil {
 				if !RestError(err, ERR_ACCESS_USER) {
-					Err("[%s]: Cannot update notification settings for user as user is not active.", string(S))
-					return
+					event.Err(err, "[%s]: Cannot update notification settings for user as user is not active.", string(S))
+					return err
 				}
 			}
 		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d folders could not be updated", failed)
+		}
+		return nil
 	}
 
-	return BulkAction(KiteUser{Deleted: false, Active: true, Suspended: false, Deactivated: false, Verified: true}, my_func)
+	return BulkAction(ctx, KiteUser{Deleted: false, Active: true, Suspended: false, Deactivated: false, Verified: true}, my_func)
+}
+
+// Saves the notification settings of a folder and of every folder nested within it to the journal.
+func journal_notifications(S KWSession, folder_id int, path string, prior KiteNotifications) error {
+	journal(journal_entry{
+		Kind:         JOURNAL_NOTIFICATIONS,
+		User:         string(S),
+		FolderID:     folder_id,
+		Path:         path,
+		FileAdded:    prior.FileAdded,
+		CommentAdded: prior.CommentAdded,
+	})
+
+	nested, err := S.ListFolders(folder_id)
+	if err != nil {
+		return err
+	}
+	for _, f := range nested {
+		if err := work_context().Err(); err != nil {
+			return err
+		}
+		settings, err := S.GetNotifications(f.ID)
+		if err != nil {
+			return err
+		}
+		if err := journal_notifications(S, f.ID, fmt.Sprintf("%s/%s", path, f.Name), settings); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func init() {
+	global.menu.RegisterCommand("config", "View, change or test API settings: config get [setting], config set <setting>=<value>, config test.", config_task)
+}
+
+// Non-interactive configuration.
+func config_task(flag *task) (err error) {
+	show_secrets := flag.Bool("show-secrets", false, "Show client secret and signature with config get.")
+	if err := flag.Parse(); err != nil {
+		return err
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		return fmt.Errorf("Please specify get, set or test.")
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "get":
+		return config_get(args[1:], *show_secrets)
+	case "set":
+		return config_set(args[1:])
+	case "test":
+		return config_test()
+	default:
+		return fmt.Errorf("Unknown config command '%s', please specify get, set or test.", args[0])
+	}
+}
+
+// Shows settings in effect, including any from --config-file or the environment.
+func config_get(keys []string, show_secrets bool) error {
+	if len(keys) == 0 {
+		keys = config_keys()
+	}
+	for _, k := range keys {
+		value, err := global.config.get(k)
+		if err != nil {
+			return err
+		}
+		if _, secret := config_secrets[strings.ToLower(k)]; secret && !show_secrets && value != NONE {
+			value = strings.Repeat("*", len(value))
+		}
+		if len(keys) == 1 {
+			Stdout(value)
+		} else {
+			Stdout("%s=%s", strings.ToLower(k), value)
+		}
+	}
+	return nil
+}
+
+// Changes saved settings, ie.. config set server=kiteworks.domain.com admin_account=admin@domain.com
+func config_set(pairs []string) error {
+	if len(pairs) == 0 {
+		return fmt.Errorf("Please specify settings to change as setting=value.")
+	}
+
+	// Only saved settings are changed, overrides from file or environment are left out.
+	cfg, found := saved_config()
+	if !found {
+		cfg.SSLVerify = true
+		cfg.ProxyURI = no_proxy
+		cfg.RedirectURI = fmt.Sprintf("https://%s/", APPNAME)
+	}
+
+	for _, p := range pairs {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("Invalid setting '%s', should be setting=value.", p)
+		}
+		if err := cfg.set(kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+
+	cfg.Tested = false
+	save_config(&cfg)
+	Log("Saved %d settings to profile '%s'.", len(pairs), global.profile)
+	return nil
+}
+
+// Tests the settings in effect against kiteworks.
+func config_test() error {
+	if !global.config.configured() {
+		return fmt.Errorf("Settings are incomplete, server, admin_account, client_id, client_secret and signature are required.")
+	}
+	if err := test_api(); err != nil {
+		return fmt.Errorf("API test failed: %s", err.Error())
+	}
+
+	// Record the test on the saved settings, when they're what was tested.
+	if saved, found := saved_config(); found {
+		tested := global.config
+		tested.Tested, saved.Tested = true, true
+		if tested == saved {
+			save_config(&saved)
+		}
+	}
+
+	Log("API Tested Successfully!")
+	return nil
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	global.menu.RegisterCommand("daemon", "Run scheduled tasks until interrupted, see the schedule command.", daemon_task)
+}
+
+// Longest the daemon sleeps before reloading the schedule.
+const DAEMON_POLL = time.Minute
+
+// How often the daemon refreshes its lock, and how long until a lock that isn't refreshed is stale.
+const (
+	DAEMON_HEARTBEAT = time.Second * 30
+	DAEMON_STALE     = time.Minute * 2
+)
+
+// Lock held by the running daemon.
+type daemon_lock struct {
+	PID     int    `json:"pid"`
+	Host    string `json:"host"`
+	Started string `json:"started"`
+}
+
+// Runs scheduled tasks as they come due.
+func daemon_task(flag *task) (err error) {
+	schedule_file := flag.String("schedule-file", "<schedule.yml|schedule.json>", "Read the schedule from a file instead of kitetool.db.")
+	if err := flag.Parse(); err != nil {
+		return err
+	}
+
+	load := func() (entries []*schedule_entry, err error) {
+		if *schedule_file != NONE {
+			return load_schedule_file(*schedule_file)
+		}
+		for _, e := range saved_schedule() {
+			if e.Profile == global.profile {
+				entries = append(entries, e)
+			}
+		}
+		return entries, nil
+	}
+
+	entries, err := load()
+	if err != nil {
+		return err
+	}
+
+	release, err := daemon_lock_acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// The daemon holds the API session for all the tasks run from it.
+	setup(false)
+
+	Log("--> %s daemon started for profile '%s' with %d scheduled tasks.", APPNAME, global.profile, len(entries))
+	if len(entries) > 0 {
+		show_schedule(entries)
+	} else {
+		warn_empty_schedule(*schedule_file)
+	}
+
+	due := make(map[string]time.Time)
+
+	for {
+		// Keep the due times of entries that haven't changed since the last reload.
+		current := make(map[string]time.Time)
+		now := time.Now()
+		for _, e := range entries {
+			k := e.key()
+			if t, ok := due[k]; ok {
+				current[k] = t
+			} else {
+				current[k] = e.spec.next(now)
+			}
+		}
+		due = current
+
+		// Entries that never come due are left out, so they don't hold up the others.
+		var pending []*schedule_entry
+		for _, e := range entries {
+			if !due[e.key()].IsZero() {
+				pending = append(pending, e)
+			}
+		}
+		sort.SliceStable(pending, func(i, j int) bool { return due[pending[i].key()].Before(due[pending[j].key()]) })
+
+		wait := DAEMON_POLL
+		if len(pending) > 0 {
+			if next := due[pending[0].key()]; next.Sub(now) < wait {
+				wait = next.Sub(now)
+			}
+		}
+
+		if wait > 0 {
+			select {
+			case <-work_context().Done():
+				Log("--> %s daemon stopped.", APPNAME)
+				return nil
+			case <-time.After(wait):
+			}
+		}
+
+		for _, e := range pending {
+			k := e.key()
+			if due[k].After(time.Now()) {
+				continue
+			}
+			run_scheduled(e, due[k])
+			due[k] = e.spec.next(time.Now())
+			if cancelled() {
+				Log("--> %s daemon stopped.", APPNAME)
+				return nil
+			}
+		}
+
+		if reloaded, err := load(); err != nil {
+			Err("Unable to reload schedule, keeping the current one: %s", err.Error())
+		} else {
+			if len(reloaded) == 0 && len(entries) > 0 {
+				warn_empty_schedule(*schedule_file)
+			}
+			entries = reloaded
+		}
+	}
+}
+
+// Warns that the daemon has nothing to run, pointing out entries scheduled for other profiles.
+func warn_empty_schedule(schedule_file string) {
+	if schedule_file != NONE {
+		Warn("Schedule file %s has no entries, the daemon has nothing to run.", schedule_file)
+		return
+	}
+	var others []string
+	seen := make(map[string]struct{})
+	for _, e := range saved_schedule() {
+		if _, ok := seen[e.Profile]; !ok && e.Profile != global.profile {
+			seen[e.Profile] = struct{}{}
+			others = append(others, fmt.Sprintf("'%s'", e.Profile))
+		}
+	}
+	if len(others) == 0 {
+		Warn("No tasks are scheduled for profile '%s', the daemon has nothing to run until one is added.", global.profile)
+		return
+	}
+	sort.Strings(others)
+	Warn("No tasks are scheduled for profile '%s', the daemon has nothing to run. Tasks are scheduled for profiles %s, select one with --profile <name>.", global.profile, strings.Join(others, ", "))
+}
+
+// Returns a key identifying the entry and its schedule, so edited entries are rescheduled.
+func (s *schedule_entry) key() string {
+	return fmt.Sprintf("%s|%s|%s", s.ID, s.Cron, s.command())
+}
+
+// Runs a schedule entry that came due at due, recording its outcome.
+// Runs that came due while the entry or an earlier one was still running are skipped rather than stacked.
+func run_scheduled(e *schedule_entry, due time.Time) {
+	Log("--> Running schedule entry %s: %s", e.ID, e.command())
+
+	started := time.Now()
+	last := last_run_id()
+
+	err := global.menu.Select(e.Args)
+
+	outcome := schedule_outcome{
+		Entry:    e.ID,
+		Args:     e.Args,
+		Profile:  global.profile,
+		Started:  started.UTC().Format(time.RFC3339),
+		Duration: time.Since(started).Round(time.Second).String(),
+		Errors:   global.errors.Get(),
+	}
+	if id := last_run_id(); id != last {
+		outcome.RunID = id
+	}
+
+	switch {
+	case err == nil:
+		show_summary()
+		outcome.Status = RUN_COMPLETED
+		if cancelled() {
+			outcome.Status = RUN_CANCELLED
+		}
+	case err == ErrTaskFailed:
+		outcome.Status = RUN_FAILED
+	default:
+		Err("Schedule entry %s: %s", e.ID, err.Error())
+		outcome.Status = RUN_FAILED
+		outcome.Message = err.Error()
+	}
+
+	var skipped int
+	ended := time.Now()
+	for t := e.spec.next(due); !t.IsZero() && !t.After(ended); t = e.spec.next(t) {
+		skipped++
+	}
+	if skipped > 0 {
+		Warn("Schedule entry %s: skipped %d runs that came due while a previous run was still going.", e.ID, skipped)
+		save_outcome(schedule_outcome{
+			Entry:   e.ID,
+			Args:    e.Args,
+			Profile: global.profile,
+			Started: ended.UTC().Format(time.RFC3339),
+			Status:  RUN_SKIPPED,
+			Message: fmt.Sprintf("%d runs skipped, previous run still going.", skipped),
+		})
+	}
+	record_outcome(e, outcome)
+
+	Log("Schedule entry %s %s with %d errors, next run at %s.\n", e.ID, outcome.Status, outcome.Errors, e.spec.next(ended).Format(time.RFC1123))
+}
+
+// Returns the daemon lock file of the profile, kept next to kitetool.db.
+func daemon_lock_file() string {
+	profile := global.profile
+	if profile == NONE {
+		profile = "default"
+	}
+	return fmt.Sprintf("%s-daemon-%s.lock", APPNAME, profile)
+}
+
+// Reads a daemon lock file, modified is when its daemon last refreshed it.
+func read_daemon_lock(filename string) (lock daemon_lock, modified time.Time, err error) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return lock, modified, err
+	}
+	modified = fi.ModTime()
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return lock, modified, err
+	}
+	err = json.Unmarshal(data, &lock)
+	return lock, modified, err
+}
+
+// Takes the daemon lock of the profile, so a second daemon doesn't run the same schedule.
+// The lock file is created exclusively, a lock that hasn't been refreshed within DAEMON_STALE is taken over.
+func daemon_lock_acquire() (release func(), err error) {
+	filename := daemon_lock_file()
+
+	host, _ := os.Hostname()
+	lock := daemon_lock{
+		PID:     os.Getpid(),
+		Host:    host,
+		Started: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+
+	running := func(existing daemon_lock) error {
+		return fmt.Errorf("A daemon for profile '%s' is already running. (pid %d on %s, started %s, lock file %s)", global.profile, existing.PID, existing.Host, existing.Started, filename)
+	}
+
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			data, _ := json.Marshal(&lock)
+			_, err = f.Write(data)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(filename)
+				return nil, fmt.Errorf("Unable to write daemon lock %s: %s", filename, err.Error())
+			}
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("Unable to create daemon lock %s: %s", filename, err.Error())
+		}
+
+		existing, modified, err := read_daemon_lock(filename)
+		if os.IsNotExist(err) && attempt == 0 {
+			continue
+		}
+		if time.Since(modified) < DAEMON_STALE || attempt > 0 {
+			return nil, running(existing)
+		}
+
+		// Move the stale lock aside, only one daemon can move it.
+		stale := fmt.Sprintf("%s.%d.stale", filename, os.Getpid())
+		if err := os.Rename(filename, stale); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("Unable to remove stale daemon lock %s: %s", filename, err.Error())
+		}
+		// Another daemon replaced the stale lock before it was moved, put its lock back.
+		if moved, _, _ := read_daemon_lock(stale); moved != existing {
+			os.Link(stale, filename)
+			os.Remove(stale)
+			return nil, running(moved)
+		}
+		os.Remove(stale)
+		Warn("Took over stale daemon lock of pid %d on %s, last refreshed %s.", existing.PID, existing.Host, modified.Format(time.RFC1123))
+	}
+
+	// Confirm the lock is ours, in case another daemon took over the same stale lock.
+	held := func() bool {
+		current, _, err := read_daemon_lock(filename)
+		return err == nil && current == lock
+	}
+	if !held() {
+		existing, _, _ := read_daemon_lock(filename)
+		return nil, running(existing)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(DAEMON_HEARTBEAT)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if !held() {
+					Err("Daemon lock %s is no longer held by this daemon, stopping.", filename)
+					cancel_run()
+					return
+				}
+				now := time.Now()
+				os.Chtimes(filename, now, now)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		if held() {
+			os.Remove(filename)
+		}
+	}, nil
+}
@@ -1,19 +1,23 @@
 package main
 
 import (
-	"github.com/cmcoffee/go-nfo"
+	"context"
+	"fmt"
 	"strings"
 	"sync"
 	"time"
-	//"fmt"
 )
 
 func init() {
 	global.menu.Register("folder-file-expiry", "Set folder and file expiries for users.", BulkFileExpire)
 }
 
-// Update folder & file expiration time.
-func (b bulk_file_expiry) update_files_expiry(folder KiteFolder, folder_names []string) {
+// Update folder & file expiration time, nested folders are not started once ctx is cancelled.
+func (b *bulk_file_expiry) update_files_expiry(ctx context.Context, folder KiteFolder, folder_names []string) {
+	if ctx.Err() != nil {
+		return
+	}
+	defer progress_folder()
 
 	S := b.KWSession
 
@@ -36,7 +40,8 @@ func (b bulk_file_expiry) update_files_expiry(folder KiteFolder, folder_names []
 	case string:
 		cur_folder_expiry, err = read_kw_time(e)
 		if err != nil {
-			nfo.Err(err)
+			Err(err)
+			b.failed.Add(1)
 			return
 		}
 	}
@@ -107,21 +112,53 @@ func (b bulk_file_expiry) update_files_expiry(folder KiteFolder, folder_names []
 		}
 	}
 
+	event := Event{
+		User:     string(S),
+		FolderID: folder.ID,
+		Path:     folder_path,
+	}
+
+	row := report_row{
+		User:            string(S),
+		FolderID:        folder.ID,
+		FolderPath:      folder_path,
+		OldExpiry:       expiry_string(cur_folder_expiry),
+		NewExpiry:       expiry_string(cur_folder_expiry),
+		OldFileLifetime: original_file_days,
+		NewFileLifetime: original_file_days,
+	}
+
 	if !b.only_extend_files {
+		event.Action = "set_expiry"
+		event.Old = map[string]interface{}{"expire": expiry_string(cur_folder_expiry), "file_lifetime": original_file_days}
+		event.New = map[string]interface{}{"expire": expiry_string(new_folder_expiry), "file_lifetime": folder.FileLifetime}
 		if !new_folder_expiry.IsZero() {
 			if folder.FileLifetime == 0 {
-				nfo.Log("%s [%d]: Folder Expiry: %v - File Expiry: Expires with folder.", folder_path, folder.ID, dateString(new_folder_expiry))
+				event.Log("%s [%d]: Folder Expiry: %v - File Expiry: Expires with folder.", folder_path, folder.ID, dateString(new_folder_expiry))
 			} else {
-				nfo.Log("%s [%d]: Folder Expiry: %v - File Expiry: %d days.", folder_path, folder.ID, dateString(new_folder_expiry), folder.FileLifetime)
+				event.Log("%s [%d]: Folder Expiry: %v - File Expiry: %d days.", folder_path, folder.ID, dateString(new_folder_expiry), folder.FileLifetime)
 			}
 		} else {
 			if folder.FileLifetime == 0 {
-				nfo.Log("%s [%d]: Folder Expiry: Never expires - File Expiry: Never expires.", folder_path, folder.ID)
+				event.Log("%s [%d]: Folder Expiry: Never expires - File Expiry: Never expires.", folder_path, folder.ID)
 			} else {
-				nfo.Log("%s [%d]: Folder Expiry: Never expires - File Expiry: %d days.", folder_path, folder.ID, folder.FileLifetime)
+				event.Log("%s [%d]: Folder Expiry: Never expires - File Expiry: %d days.", folder_path, folder.ID, folder.FileLifetime)
 			}
 		}
 
+		// Save the folder's current settings for rollback.
+		prior := journal_entry{
+			Kind:         JOURNAL_EXPIRY,
+			User:         string(S),
+			FolderID:     folder.ID,
+			Path:         folder_path,
+			FileLifetime: original_file_days,
+		}
+		if !cur_folder_expiry.IsZero() {
+			prior.Expire = write_kw_time(cur_folder_expiry)
+		}
+		journal(prior)
+
 		// Set folder expiration
 		if !new_folder_expiry.IsZero() {
 			err = S.SetFolderAndFileExpiry(folder.ID, new_folder_expiry, folder.FileLifetime)
@@ -129,8 +166,14 @@ func (b bulk_file_expiry) update_files_expiry(folder KiteFolder, folder_names []
 			err = S.SetFolderAndFileExpiry(folder.ID, 0, folder.FileLifetime)
 		}
 
+		row.Action = event.Action
+		row.NewExpiry = expiry_string(new_folder_expiry)
+		row.NewFileLifetime = folder.FileLifetime
+		report_change(row, err)
+
 		if err != nil {
-			nfo.Err("%s [%d]: %s", folder_path, folder.ID, err.Error())
+			event.Err(err, "%s [%d]: %s", folder_path, folder.ID, err.Error())
+			b.failed.Add(1)
 			return
 		}
 	} else {
@@ -138,26 +181,41 @@ func (b bulk_file_expiry) update_files_expiry(folder KiteFolder, folder_names []
 		if cur_folder_expiry.IsZero() {
 			date_string = "Never expires."
 		}
-		nfo.Log("%s [%d]: Reapplying folder's file expirations to files. [folder: %s file_exp:%d days]", folder_path, folder.ID, date_string, original_file_days)
-		if err := S.ReapplyFileLifetime(folder.ID); err != nil {
-			nfo.Err(err)
+		event.Action = "reapply_file_expiry"
+		event.New = map[string]interface{}{"expire": expiry_string(cur_folder_expiry), "file_lifetime": original_file_days}
+		event.Log("%s [%d]: Reapplying folder's file expirations to files. [folder: %s file_exp:%d days]", folder_path, folder.ID, date_string, original_file_days)
+		err := S.ReapplyFileLifetime(folder.ID)
+		row.Action = event.Action
+		report_change(row, err)
+		if err != nil {
+			event.Err(err, "%s [%d]: %s", folder_path, folder.ID, err.Error())
+			b.failed.Add(1)
 		}
 	}
 
 	nested_folders, err := S.ListFolders(folder.ID)
 	if err != nil {
-		nfo.Err(err)
+		Err(err)
+		b.failed.Add(1)
 		return
 	}
 	for _, f := range nested_folders {
-		folder_names = append(folder_names, f.Name)
 		if b.checkout_folder(f, false) {
-			b.update_files_expiry(f, folder_names)
+			// Each child gets its own copy of the path, so siblings don't share or extend it.
+			b.update_files_expiry(ctx, f, append(append([]string(nil), folder_names...), f.Name))
 		}
 	}
 
 }
 
+// Returns expiry as a date, or "never" when unset.
+func expiry_string(expiry time.Time) string {
+	if expiry.IsZero() {
+		return "never"
+	}
+	return dateString(expiry)
+}
+
 // Prevents folders with multiple users from modifying the same folders.
 func (b *bulk_file_expiry) checkout_folder(folder KiteFolder, check_permissions bool) bool {
 
@@ -177,7 +235,8 @@ func (b *bulk_file_expiry) checkout_folder(folder KiteFolder, check_permissions
 	if check_permissions {
 		f, err := User.FolderInfo(folder.ID)
 		if err != nil {
-			nfo.Err(err)
+			Err(err)
+			b.failed.Add(1)
 			return false
 		}
 
@@ -186,10 +245,10 @@ func (b *bulk_file_expiry) checkout_folder(folder KiteFolder, check_permissions
 			return true
 		} else {
 			if f.CurrentUserRole.ID == 0 {
-				nfo.Warn("Unable to check user role for user %s of folder %s.", string(User), string(folder.Name))
+				Warn("Unable to check user role for user %s of folder %s.", string(User), string(folder.Name))
 				return true
 			}
-			nfo.Log("%s is not a owner nor manager of %s... Skipping for now.", string(User), string(folder.Name))
+			Log("%s is not a owner nor manager of %s... Skipping for now.", string(User), string(folder.Name))
 			return false
 		}
 	}
@@ -210,6 +269,7 @@ type bulk_file_expiry struct {
 	max_date          time.Time
 	min_date          time.Time
 	only_extend_files bool
+	failed            stats_record
 
 	KWSession
 }
@@ -287,11 +347,13 @@ func BulkFileExpire(flag *task) (err error) {
 
 	flag.LogStart()
 
+	ctx := work_context()
+
 	// Hand-off function to BulkAction.
-	my_func := func(user KiteUser) {
+	my_func := func(user KiteUser) error {
 
 		if user.BaseDirID == 0 {
-			return
+			return nil
 		}
 
 		b := &bulk_file_expiry{
@@ -316,8 +378,8 @@ func BulkFileExpire(flag *task) (err error) {
 		if len(select_folders) == 0 {
 			folders, err := b.GetFolders()
 			if err != nil {
-				nfo.Err("%v: Unable to process user: %s", b.KWSession, err.Error())
-				return
+				Err("%v: Unable to process user: %s", b.KWSession, err.Error())
+				return err
 			}
 
 			for _, f := range folders {
@@ -325,7 +387,7 @@ func BulkFileExpire(flag *task) (err error) {
 					if f.Name == "My Folder" {
 						continue
 					}
-					b.update_files_expiry(f, []string{f.Name})
+					b.update_files_expiry(ctx, f, []string{f.Name})
 				}
 			}
 		} else {
@@ -333,25 +395,31 @@ func BulkFileExpire(flag *task) (err error) {
 				folder_name = strings.TrimPrefix(folder_name, "/")
 				folder_name = strings.TrimPrefix(folder_name, "\\")
 				if strings.Contains(folder_name, "/") || strings.Contains(folder_name, "\\") {
-					nfo.Err("[%s]: %s contains a nested folder, folders should be top-level only, skipping folder.", b.KWSession, folder_name)
+					Err("[%s]: %s contains a nested folder, folders should be top-level only, skipping folder.", b.KWSession, folder_name)
 					continue
 				}
 				f, err := b.FindFolder(folder_name)
 				if err != nil {
 					if RestError(err, ERR_ACCESS_USER) || err == ErrNotFound {
-						return
+						return nil
 					}
-					nfo.Err("[%s]: %s, skipping folder.", folder_name, err.Error())
+					Err("[%s]: %s, skipping folder.", folder_name, err.Error())
+					b.failed.Add(1)
 					continue
 				}
 				if b.checkout_folder(f, true) {
 					if f.Name == "My Folder" {
 						continue
 					}
-					b.update_files_expiry(f, []string{folder_name})
+					b.update_files_expiry(ctx, f, []string{folder_name})
 				}
 			}
 		}
+
+		if failed := b.failed.Get(); failed > 0 {
+			return fmt.Errorf("%d folders could not be updated", failed)
+		}
+		return nil
 	}
-	return BulkAction(KiteUser{Deleted: false, Active: true, Suspended: false, Deactivated: false, Verified: true}, my_func)
+	return BulkAction(ctx, KiteUser{Deleted: false, Active: true, Suspended: false, Deactivated: false, Verified: true}, my_func)
 }
@@ -18,9 +18,7 @@ func mail_cleaner(flag *task) (err error) {
 	}
 
 	type KiteMail struct {
-		Data []struct {
-			ID int `json:"id"`
-		} `json:"data"`
+		ID int `json:"id"`
 	}
 
 	flag.LogStart()
@@ -28,54 +26,71 @@ func mail_cleaner(flag *task) (err error) {
 	var drafts_deleted, files_deleted, files_total_size stats_record
 	mail_files_cleaner_func := purge_deleted_mail_files(&files_deleted, &files_total_size)
 
-	my_func := func(user KiteUser) {
+	my_func := func(user KiteUser) (err error) {
 		S := KWSession(user.Email)
 		var mail_ids []int
-		var mail KiteMail
-		var offset int
-		for {
-			err = S.Call(APIRequest{
-				Method: "GET",
-				Path:   "/rest/mail",
-				Params: SetParams(Query{"deleted": false, "offset": offset, "limit": 100, "bucket": "draft", "date:lte": write_kw_time(expire)}),
-				Output: &mail,
-			})
-			if err != nil {
-				return
-			}
-			for _, v := range mail.Data {
-				mail_ids = append(mail_ids, v.ID)
-			}
-			if len(mail.Data) < 100 {
-				break
-			}
+		var mail []KiteMail
+		err = S.DataCall(APIRequest{
+			Method: "GET",
+			Path:   "/rest/mail",
+			Params: SetParams(Query{"deleted": false, "bucket": "draft", "date:lte": write_kw_time(expire)}),
+		}, &mail)
+		if err != nil {
+			Err("[%s]: Error retrieving drafts: %s", string(S), err.Error())
+			return err
 		}
-		defer mail_files_cleaner_func(user)
+		for _, v := range mail {
+			mail_ids = append(mail_ids, v.ID)
+		}
+		defer func() {
+			if purge_err := mail_files_cleaner_func(user); err == nil {
+				err = purge_err
+			}
+		}()
 
 		if len(mail_ids) == 0 {
 			Log("[%v]: No expired drafts were found.", S)
-			return
+			return nil
 		}
-		if len(mail_ids) > 0 {
-			if err := S.Call(APIRequest{
+		// Delete in chunks, so the request stays within the server's limits.
+		for len(mail_ids) > 0 {
+			if err := work_context().Err(); err != nil {
+				return err
+			}
+			chunk := mail_ids
+			if len(chunk) > PAGE_SIZE {
+				chunk = chunk[:PAGE_SIZE]
+			}
+			mail_ids = mail_ids[len(chunk):]
+
+			event := Event{User: string(S), Action: "delete_drafts", New: chunk}
+			err := S.Call(APIRequest{
 				Method: "DELETE",
 				Path:   "/rest/mail",
-				Params: SetParams(Query{"emailId:in": mail_ids, "partialSuccess": true}),
-			}); err != nil {
-				Err("[%s]: Error deleting drafts: %s", string(S), err.Error())
+				Params: SetParams(Query{"emailId:in": chunk, "partialSuccess": true}),
+			})
+			report_change(report_row{
+				User:          string(S),
+				Action:        event.Action,
+				DraftsDeleted: len(chunk),
+			}, err)
+			if err != nil {
+				event.Err(err, "[%s]: Error deleting drafts: %s", string(S), err.Error())
+				return err
 			}
-			Log("[%v]: Overdue drafts removed: %d", S, len(mail_ids))
-			drafts_deleted.Add(int64(len(mail_ids)))
+			event.Log("[%v]: Overdue drafts removed: %d", S, len(chunk))
+			drafts_deleted.Add(int64(len(chunk)))
 		}
+		return nil
 	}
 	if flag.IsSet("expire-drafts") {
-		err = BulkAction(KiteUser{Deleted: false, Active: true, Suspended: false, Deactivated: false, Verified: true}, my_func)
+		err = BulkAction(work_context(), KiteUser{Deleted: false, Active: true, Suspended: false, Deactivated: false, Verified: true}, my_func)
 		if err != nil {
 			return err
 		}
 	}
 	if !flag.IsSet("expire-drafts") {
-		err = BulkAction(KiteUser{Deleted: false, Active: true, Suspended: false, Deactivated: false, Verified: true}, mail_files_cleaner_func)
+		err = BulkAction(work_context(), KiteUser{Deleted: false, Active: true, Suspended: false, Deactivated: false, Verified: true}, mail_files_cleaner_func)
 		if err != nil {
 			return err
 		}
@@ -91,67 +106,99 @@ func mail_cleaner(flag *task) (err error) {
 }
 
 // Deletes purged files from mail folder
-func purge_deleted_mail_files(files_deleted, files_total_size *stats_record) func(user KiteUser) {
+func purge_deleted_mail_files(files_deleted, files_total_size *stats_record) func(user KiteUser) error {
 
-	return func(user KiteUser) {
+	return func(user KiteUser) error {
 		S := KWSession(user.Email)
 
 		folder_id, err := S.MyMyDirID()
 		if err != nil {
 			Err("[%v]: Could not retrieve user's mail folder: %s", S, err.Error())
-			return
+			return err
 		}
 
 		if folder_id == 0 {
-			return
-		}
-
-		var KiteArray struct {
-			Files []KiteFile `json:"data"`
+			return nil
 		}
 
-		var offset int
 		var deleted_files []int
 		var total_size int64
+		file_sizes := make(map[int]int64)
 		var file_count int
 
+		files := S.NewPager(APIRequest{
+			APIVer: 5,
+			Method: "GET",
+			Path:   SetPath("/rest/folders/%d/files", folder_id),
+			Params: SetParams(Query{"deleted": true}),
+		}, PAGE_SIZE)
+
 		for {
-			err = S.Call(APIRequest{
-				APIVer: 5,
-				Method: "GET",
-				Path:   SetPath("/rest/folders/%d/files", folder_id),
-				Params: SetParams(Query{"deleted": true, "offset": offset, "limit": 100}),
-				Output: &KiteArray,
-			})
+			var page []KiteFile
+			more, err := files.Next(&page)
 			if err != nil {
 				Err("[%v]: Error while retriving files from mail dir: %s", S, err.Error())
-				return
+				return err
+			}
+			if !more {
+				break
 			}
-			for _, v := range KiteArray.Files {
+			for _, v := range page {
 				if !v.PermDeleted {
 					deleted_files = append(deleted_files, v.ID)
+					file_sizes[v.ID] = v.Size
 					total_size = total_size + v.Size
 					file_count++
 				}
 			}
-			if len(KiteArray.Files) < 100 {
-				break
-			}
 		}
 		if len(deleted_files) == 0 {
 			Log("[%v]: No deleted attachments found in mail folder.", S)
-			return
+			return nil
 		}
 		Log("[%v]: Purging %d deleted attachments from mail folder. (%s)", S, file_count, showSize(total_size))
-		err = S.Call(APIRequest{
-			Method: "DELETE",
-			Path:   "/rest/files/actions/permanent",
-			Params: SetParams(Query{"id:in": deleted_files, "partialSuccess": true}),
-		})
-		if err != nil {
-			Err("[%v]: Error purging deleted attachments: %s", S, err.Error())
+
+		// Purge in chunks, so the request stays within the server's limits.
+		for len(deleted_files) > 0 {
+			if err := work_context().Err(); err != nil {
+				return err
+			}
+			chunk := deleted_files
+			if len(chunk) > PAGE_SIZE {
+				chunk = chunk[:PAGE_SIZE]
+			}
+			deleted_files = deleted_files[len(chunk):]
+
+			var chunk_size int64
+			for _, id := range chunk {
+				chunk_size = chunk_size + file_sizes[id]
+			}
+
+			event := Event{
+				User:     string(S),
+				FolderID: folder_id,
+				Action:   "purge_attachments",
+				New:      map[string]int64{"files": int64(len(chunk)), "bytes": chunk_size},
+			}
+			err = S.Call(APIRequest{
+				Method: "DELETE",
+				Path:   "/rest/files/actions/permanent",
+				Params: SetParams(Query{"id:in": chunk, "partialSuccess": true}),
+			})
+			report_change(report_row{
+				User:        string(S),
+				FolderID:    folder_id,
+				Action:      event.Action,
+				BytesPurged: chunk_size,
+			}, err)
+			if err != nil {
+				event.Err(err, "[%v]: Error purging deleted attachments: %s", S, err.Error())
+				return err
+			}
+			event.Log("[%v]: Deleted attachments purged: %d (%s)", S, len(chunk), showSize(chunk_size))
+			files_deleted.Add(int64(len(chunk)))
+			files_total_size.Add(chunk_size)
 		}
-		files_deleted.Add(int64(file_count))
-		files_total_size.Add(total_size)
+		return nil
 	}
 }
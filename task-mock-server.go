@@ -0,0 +1,740 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	global.menu.RegisterCommand("mock-server", "Run a local stand-in of the kiteworks API for demos and testing.", mock_server_task)
+}
+
+// Folder roles handed out by the mock server.
+var mock_roles = map[int]FolderPermission{
+	1: {ID: 1, Name: "Viewer", Rank: 1},
+	2: {ID: 2, Name: "Downloader", Rank: 2},
+	3: {ID: 3, Name: "Collaborator", Rank: 3},
+	4: {ID: 4, Name: "Manager", Rank: 4, Modifiable: true},
+	5: {ID: 5, Name: "Owner", Rank: 5, Modifiable: true},
+}
+
+// Folder member within a mock fixture.
+type mock_member struct {
+	UserID int `json:"userId"`
+	RoleID int `json:"roleId"`
+}
+
+// Folder within a mock fixture.
+type mock_folder struct {
+	KiteFolder
+	Members []mock_member `json:"members,omitempty"`
+}
+
+// Mail within a mock fixture.
+type mock_mail struct {
+	ID      int    `json:"id"`
+	UserID  int    `json:"userId"`
+	Bucket  string `json:"bucket"`
+	Date    string `json:"date"`
+	Deleted bool   `json:"deleted"`
+}
+
+// Folder notification settings held by the mock server.
+type mock_notify struct {
+	FileAdded     bool `json:"fileAdded"`
+	CommentAdded  bool `json:"commentAdded"`
+	IncludeNested bool `json:"includeNested"`
+}
+
+// Fixture used to seed the mock server.
+type mock_fixture struct {
+	Client struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		Signature    string `json:"signature"`
+	} `json:"client"`
+	Users   []KiteUser    `json:"users"`
+	Folders []mock_folder `json:"folders"`
+	Files   []KiteFile    `json:"files"`
+	Mail    []mock_mail   `json:"mail"`
+}
+
+// Local stand-in for the kiteworks API.
+type mock_server struct {
+	mutex         sync.Mutex
+	fixture       mock_fixture
+	tokens        map[string]int
+	notifications map[string]mock_notify
+}
+
+// kiteworks API error codes returned by the mock server.
+type mock_error struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e mock_error) Error() string { return e.message }
+
+var (
+	mock_not_found    = mock_error{http.StatusNotFound, "ERR_ENTITY_NOT_FOUND", "Entity not found"}
+	mock_no_access    = mock_error{http.StatusForbidden, "ERR_ACCESS_USER", "User does not have access"}
+	mock_unauthorized = mock_error{http.StatusUnauthorized, "ERR_AUTH_UNAUTHORIZED", "Unauthorized access token"}
+	mock_bad_method   = mock_error{http.StatusMethodNotAllowed, "ERR_REQUEST_METHOD_NOT_ALLOWED", "Request method not allowed"}
+)
+
+// Runs the mock kiteworks server.
+func mock_server_task(flag *task) (err error) {
+	fixture_file := flag.String("fixture", "<fixture.json>", "JSON fixture of users, folders, files and mail to serve, built-in demo data if not set.")
+	port := flag.Int("port", 8080, "Port to listen on.")
+	bind := flag.String("bind", "127.0.0.1", "Address to listen on.")
+	save_demo := flag.String("save-demo", "<demo.json>", "Write the built-in demo fixture to file, as a starting point for your own.")
+	if err := flag.Parse(); err != nil {
+		return err
+	}
+
+	if *save_demo != NONE {
+		data, err := json.MarshalIndent(mock_demo_fixture(), "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(*save_demo, data, 0600); err != nil {
+			return err
+		}
+		Log("Demo fixture written to %s.", *save_demo)
+		return nil
+	}
+
+	m := &mock_server{
+		tokens:        make(map[string]int),
+		notifications: make(map[string]mock_notify),
+	}
+
+	if *fixture_file != NONE {
+		data, err := ioutil.ReadFile(*fixture_file)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &m.fixture); err != nil {
+			return fmt.Errorf("Unable to read fixture %s: %s", *fixture_file, err.Error())
+		}
+	} else {
+		m.fixture = mock_demo_fixture()
+	}
+
+	// Fall back to configured API credentials when the fixture doesn't set them.
+	client := &m.fixture.Client
+	if client.ClientID == NONE {
+		client.ClientID = global.config.ClientID
+		client.ClientSecret = global.config.ClientSecret
+		client.Signature = global.config.Signature
+	}
+	if client.ClientID == NONE || client.ClientSecret == NONE || client.Signature == NONE {
+		return Error("No client credentials found in fixture or configuration, please provide a \"client\" section in the fixture.")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/token", m.handle_token)
+	mux.HandleFunc("/rest/", m.handle_rest)
+
+	addr := net.JoinHostPort(*bind, strconv.Itoa(*port))
+
+	Log("--> %s mock kiteworks server listening on http://%s", APPNAME, addr)
+	Log("    Users: %d, Folders: %d, Files: %d, Mail: %d", len(m.fixture.Users), len(m.fixture.Folders), len(m.fixture.Files), len(m.fixture.Mail))
+	Log("    Client App ID: %s", client.ClientID)
+	Log("    Configure %s with --setup: host %s, connection plain HTTP on port %d.", APPNAME, *bind, *port)
+	Log("\n")
+
+	return http.ListenAndServe(addr, mux)
+}
+
+// Writes v as a JSON response.
+func mock_reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if v != nil {
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+// Writes a kiteworks style error response.
+func mock_fail(w http.ResponseWriter, err error) {
+	e, ok := err.(mock_error)
+	if !ok {
+		e = mock_error{http.StatusInternalServerError, "ERR_INTERNAL_SERVER_ERROR", err.Error()}
+	}
+	mock_reply(w, e.status, map[string]interface{}{
+		"errors": []map[string]string{{"code": e.code, "message": e.message}},
+	})
+}
+
+// Writes a paged listing, honoring limit and offset.
+func mock_list(w http.ResponseWriter, r *http.Request, data []interface{}) {
+	q := r.URL.Query()
+	total := len(data)
+
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	if offset < 0 || offset > total {
+		offset = total
+	}
+
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = total
+	}
+
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+
+	mock_reply(w, http.StatusOK, map[string]interface{}{
+		"data":     append([]interface{}{}, data[offset:end]...),
+		"metadata": KiteMetadata{Total: total, Limit: limit, Offset: offset},
+	})
+}
+
+// Splits an "id:in" style list of ids.
+func mock_ids(input string) (output []int) {
+	for _, v := range strings.Split(input, ",") {
+		if id, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			output = append(output, id)
+		}
+	}
+	return
+}
+
+// Validates the signature authorization code and hands out an access token.
+func (m *mock_server) handle_token(w http.ResponseWriter, r *http.Request) {
+	grant_err := func(desc string) {
+		mock_reply(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": desc})
+	}
+
+	if r.Method != http.MethodPost {
+		mock_fail(w, mock_bad_method)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		grant_err(err.Error())
+		return
+	}
+
+	client := m.fixture.Client
+
+	if r.PostForm.Get("client_id") != client.ClientID || r.PostForm.Get("client_secret") != client.ClientSecret {
+		mock_reply(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized_client", "error_description": "Invalid client credentials"})
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		grant_err("Unsupported grant type")
+		return
+	}
+
+	code := strings.Split(r.PostForm.Get("code"), "|@@|")
+	if len(code) != 5 {
+		grant_err("Malformed authorization code")
+		return
+	}
+
+	client_id, err := base64.StdEncoding.DecodeString(code[0])
+	if err != nil || string(client_id) != client.ClientID {
+		grant_err("Authorization code was not issued for this client")
+		return
+	}
+
+	username, err := base64.StdEncoding.DecodeString(code[1])
+	if err != nil {
+		grant_err("Malformed authorization code")
+		return
+	}
+
+	timestamp, err := strconv.ParseInt(code[2], 10, 64)
+	if err != nil {
+		grant_err("Malformed authorization code")
+		return
+	}
+	if skew := time.Since(time.Unix(timestamp, 0)); skew > time.Minute*10 || skew < -time.Minute*10 {
+		grant_err("Authorization code has expired")
+		return
+	}
+
+	mac := hmac.New(sha1.New, []byte(client.Signature))
+	mac.Write([]byte(fmt.Sprintf("%s|@@|%s|@@|%s|@@|%s", client_id, username, code[2], code[3])))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(code[4])) {
+		grant_err("Invalid signature")
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user := m.find_user(string(username))
+	if user == nil {
+		grant_err("User not found")
+		return
+	}
+
+	token := string(randBytes(32))
+	m.tokens[token] = user.ID
+
+	Log("[mock] Token issued for %s.", user.Email)
+
+	mock_reply(w, http.StatusOK, map[string]interface{}{
+		"access_token": token,
+		"expires_in":   3600,
+		"token_type":   "bearer",
+		"scope":        "*/*/*",
+	})
+}
+
+// Routes /rest/ requests.
+func (m *mock_server) handle_rest(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user := m.auth_user(r)
+	if user == nil {
+		mock_fail(w, mock_unauthorized)
+		return
+	}
+
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/rest/"), "/"), "/")
+
+	Log("[mock] %s: %s %s", user.Email, r.Method, r.URL.RequestURI())
+
+	var err error
+
+	switch {
+	case path[0] == "users" && len(path) == 1:
+		err = m.get_users(w, r)
+	case path[0] == "users" && len(path) == 2:
+		err = m.get_user(w, r, user, path[1])
+	case path[0] == "admin" && len(path) == 2 && path[1] == "users":
+		err = m.admin_users(w, r)
+	case path[0] == "folders" && len(path) == 2 && path[1] == "top":
+		err = m.top_folders(w, r, user)
+	case path[0] == "folders" && len(path) >= 2:
+		var id int
+		if id, err = strconv.Atoi(path[1]); err != nil {
+			err = mock_not_found
+			break
+		}
+		err = m.folder(w, r, user, id, path[2:])
+	case path[0] == "mail" && len(path) == 1:
+		err = m.mail(w, r, user)
+	case path[0] == "files" && len(path) == 3 && path[1] == "actions" && path[2] == "permanent":
+		err = m.purge_files(w, r, user)
+	default:
+		err = mock_not_found
+	}
+
+	if err != nil {
+		mock_fail(w, err)
+	}
+}
+
+// Returns the user for the bearer token of r.
+func (m *mock_server) auth_user(r *http.Request) *KiteUser {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if id, ok := m.tokens[token]; ok {
+		return m.user_by_id(id)
+	}
+	return nil
+}
+
+func (m *mock_server) find_user(email string) *KiteUser {
+	for i, u := range m.fixture.Users {
+		if strings.ToLower(u.Email) == strings.ToLower(email) {
+			return &m.fixture.Users[i]
+		}
+	}
+	return nil
+}
+
+func (m *mock_server) user_by_id(id int) *KiteUser {
+	for i, u := range m.fixture.Users {
+		if u.ID == id {
+			return &m.fixture.Users[i]
+		}
+	}
+	return nil
+}
+
+// GET /rest/users
+func (m *mock_server) get_users(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return mock_bad_method
+	}
+	var data []interface{}
+	email := r.URL.Query().Get("email")
+	for _, u := range m.fixture.Users {
+		if email == NONE || strings.ToLower(u.Email) == strings.ToLower(email) {
+			data = append(data, u)
+		}
+	}
+	mock_list(w, r, data)
+	return nil
+}
+
+// GET /rest/users/{id} and /rest/users/me
+func (m *mock_server) get_user(w http.ResponseWriter, r *http.Request, user *KiteUser, id string) error {
+	if r.Method != http.MethodGet {
+		return mock_bad_method
+	}
+	if id == "me" {
+		mock_reply(w, http.StatusOK, user)
+		return nil
+	}
+	user_id, err := strconv.Atoi(id)
+	if err != nil {
+		return mock_not_found
+	}
+	if u := m.user_by_id(user_id); u != nil {
+		mock_reply(w, http.StatusOK, u)
+		return nil
+	}
+	return mock_not_found
+}
+
+// GET /rest/admin/users
+func (m *mock_server) admin_users(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return mock_bad_method
+	}
+	var data []interface{}
+	for _, u := range m.fixture.Users {
+		data = append(data, u)
+	}
+	mock_list(w, r, data)
+	return nil
+}
+
+// Returns the role user holds on folder, nil if user has no access.
+func (m *mock_server) role(user *KiteUser, folder *mock_folder) *FolderPermission {
+	for f := folder; f != nil; f = m.find_folder(f.ParentID) {
+		if f.UserID == user.ID {
+			role := mock_roles[5]
+			return &role
+		}
+		for _, member := range f.Members {
+			if member.UserID == user.ID {
+				role := mock_roles[member.RoleID]
+				return &role
+			}
+		}
+	}
+	return nil
+}
+
+func (m *mock_server) find_folder(id int) *mock_folder {
+	if id == 0 {
+		return nil
+	}
+	for i, f := range m.fixture.Folders {
+		if f.ID == id {
+			return &m.fixture.Folders[i]
+		}
+	}
+	return nil
+}
+
+// Returns folder as seen by user.
+func (m *mock_server) folder_view(user *KiteUser, folder *mock_folder) KiteFolder {
+	output := folder.KiteFolder
+	if role := m.role(user, folder); role != nil {
+		output.CurrentUserRole = *role
+	}
+	return output
+}
+
+// Returns true if the deleted filter of r matches deleted.
+func mock_deleted_match(r *http.Request, deleted bool) bool {
+	filter := r.URL.Query().Get("deleted")
+	if filter == NONE {
+		return !deleted
+	}
+	want, _ := strconv.ParseBool(filter)
+	return want == deleted
+}
+
+// GET /rest/folders/top
+func (m *mock_server) top_folders(w http.ResponseWriter, r *http.Request, user *KiteUser) error {
+	if r.Method != http.MethodGet {
+		return mock_bad_method
+	}
+	var data []interface{}
+	for i := range m.fixture.Folders {
+		f := &m.fixture.Folders[i]
+		if !mock_deleted_match(r, f.Deleted) {
+			continue
+		}
+		parent := m.find_folder(f.ParentID)
+		if parent != nil && m.role(user, parent) != nil {
+			continue
+		}
+		if m.role(user, f) != nil {
+			data = append(data, m.folder_view(user, f))
+		}
+	}
+	mock_list(w, r, data)
+	return nil
+}
+
+// Handles /rest/folders/{id} and everything beneath it.
+func (m *mock_server) folder(w http.ResponseWriter, r *http.Request, user *KiteUser, id int, action []string) error {
+	folder := m.find_folder(id)
+
+	// The user's base directory holds their top level folders, but isn't a folder itself.
+	if folder == nil && id == user.BaseDirID && strings.Join(action, "/") == "folders" && r.Method == http.MethodGet {
+		var data []interface{}
+		for i := range m.fixture.Folders {
+			f := &m.fixture.Folders[i]
+			if f.ParentID == id && mock_deleted_match(r, f.Deleted) {
+				data = append(data, m.folder_view(user, f))
+			}
+		}
+		mock_list(w, r, data)
+		return nil
+	}
+
+	if folder == nil {
+		return mock_not_found
+	}
+	role := m.role(user, folder)
+	if role == nil {
+		return mock_no_access
+	}
+
+	switch strings.Join(action, "/") {
+	case "":
+		switch r.Method {
+		case http.MethodGet:
+			mock_reply(w, http.StatusOK, m.folder_view(user, folder))
+			return nil
+		case http.MethodPut:
+			if role.ID < 4 {
+				return mock_no_access
+			}
+			return m.update_folder(w, r, folder)
+		}
+		return mock_bad_method
+	case "folders":
+		if r.Method != http.MethodGet {
+			return mock_bad_method
+		}
+		var data []interface{}
+		for i := range m.fixture.Folders {
+			f := &m.fixture.Folders[i]
+			if f.ParentID == id && mock_deleted_match(r, f.Deleted) {
+				data = append(data, m.folder_view(user, f))
+			}
+		}
+		mock_list(w, r, data)
+		return nil
+	case "files":
+		if r.Method != http.MethodGet {
+			return mock_bad_method
+		}
+		var data []interface{}
+		for _, f := range m.fixture.Files {
+			if f.ParentID == id && !f.PermDeleted && mock_deleted_match(r, f.Deleted) {
+				data = append(data, f)
+			}
+		}
+		mock_list(w, r, data)
+		return nil
+	case "notifications":
+		if r.Method != http.MethodGet {
+			return mock_bad_method
+		}
+		n := m.notifications[fmt.Sprintf("%d:%d", user.ID, id)]
+		mock_reply(w, http.StatusOK, n)
+		return nil
+	case "actions/setNotifications":
+		if r.Method != http.MethodPut {
+			return mock_bad_method
+		}
+		if err := r.ParseForm(); err != nil {
+			return err
+		}
+		nested, _ := strconv.ParseBool(r.URL.Query().Get("includeNested"))
+		m.notifications[fmt.Sprintf("%d:%d", user.ID, id)] = mock_notify{
+			FileAdded:     r.PostForm.Get("fileAdded") == "1",
+			CommentAdded:  r.PostForm.Get("commentAdded") == "1",
+			IncludeNested: nested,
+		}
+		mock_reply(w, http.StatusOK, nil)
+		return nil
+	}
+	return mock_not_found
+}
+
+// PUT /rest/folders/{id}
+func (m *mock_server) update_folder(w http.ResponseWriter, r *http.Request, folder *mock_folder) error {
+	var update struct {
+		Expire       interface{} `json:"expire"`
+		FileLifetime *int        `json:"fileLifetime"`
+		ApplyToFiles bool        `json:"applyFileLifetimeToFiles"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		return mock_error{http.StatusBadRequest, "ERR_INPUT_INVALID", err.Error()}
+	}
+
+	switch e := update.Expire.(type) {
+	case float64:
+		folder.Expire = 0
+	case string:
+		t, err := time.Parse("2006-01-02", e)
+		if err != nil {
+			return mock_error{http.StatusBadRequest, "ERR_INPUT_INVALID", fmt.Sprintf("Invalid expire date: %s", e)}
+		}
+		folder.Expire = write_kw_time(t)
+	}
+
+	if update.FileLifetime != nil {
+		folder.FileLifetime = *update.FileLifetime
+	}
+
+	if update.ApplyToFiles {
+		for i, f := range m.fixture.Files {
+			if f.ParentID != folder.ID {
+				continue
+			}
+			if folder.FileLifetime == 0 {
+				m.fixture.Files[i].Expire = folder.Expire
+			} else {
+				created, err := read_kw_time(f.Created)
+				if err != nil {
+					created = time.Now()
+				}
+				m.fixture.Files[i].Expire = write_kw_time(created.Add(time.Hour * 24 * time.Duration(folder.FileLifetime)))
+			}
+		}
+	}
+
+	mock_reply(w, http.StatusOK, folder.KiteFolder)
+	return nil
+}
+
+// GET and DELETE /rest/mail
+func (m *mock_server) mail(w http.ResponseWriter, r *http.Request, user *KiteUser) error {
+	q := r.URL.Query()
+
+	switch r.Method {
+	case http.MethodGet:
+		var before time.Time
+		if lte := q.Get("date:lte"); lte != NONE {
+			t, err := read_kw_time(lte)
+			if err != nil {
+				return mock_error{http.StatusBadRequest, "ERR_INPUT_INVALID", fmt.Sprintf("Invalid date: %s", lte)}
+			}
+			before = t
+		}
+		var data []interface{}
+		for _, v := range m.fixture.Mail {
+			if v.UserID != user.ID || !mock_deleted_match(r, v.Deleted) {
+				continue
+			}
+			if bucket := q.Get("bucket"); bucket != NONE && bucket != v.Bucket {
+				continue
+			}
+			if !before.IsZero() {
+				if sent, err := read_kw_time(v.Date); err != nil || sent.After(before) {
+					continue
+				}
+			}
+			data = append(data, map[string]interface{}{"id": v.ID, "date": v.Date, "bucket": v.Bucket})
+		}
+		mock_list(w, r, data)
+		return nil
+	case http.MethodDelete:
+		for _, id := range mock_ids(q.Get("emailId:in")) {
+			for i, v := range m.fixture.Mail {
+				if v.ID == id && v.UserID == user.ID {
+					m.fixture.Mail[i].Deleted = true
+				}
+			}
+		}
+		mock_reply(w, http.StatusOK, nil)
+		return nil
+	}
+	return mock_bad_method
+}
+
+// DELETE /rest/files/actions/permanent
+func (m *mock_server) purge_files(w http.ResponseWriter, r *http.Request, user *KiteUser) error {
+	if r.Method != http.MethodDelete {
+		return mock_bad_method
+	}
+	for _, id := range mock_ids(r.URL.Query().Get("id:in")) {
+		for i, f := range m.fixture.Files {
+			if f.ID != id || !f.Deleted {
+				continue
+			}
+			if folder := m.find_folder(f.ParentID); folder != nil && m.role(user, folder) != nil {
+				m.fixture.Files[i].PermDeleted = true
+			}
+		}
+	}
+	mock_reply(w, http.StatusOK, nil)
+	return nil
+}
+
+// Built-in demo data for when no fixture is provided.
+func mock_demo_fixture() (f mock_fixture) {
+	f.Client.ClientID = "kitetool-demo"
+	f.Client.ClientSecret = "demo-secret"
+	f.Client.Signature = "demo-signature"
+
+	now := time.Now().UTC()
+	kw_date := func(days int) string {
+		return write_kw_time(now.Add(time.Hour * 24 * time.Duration(days)))
+	}
+
+	names := []string{"admin", "alice", "bob"}
+	for i, name := range names {
+		id := i + 1
+		f.Users = append(f.Users, KiteUser{
+			ID:        id,
+			Active:    true,
+			Verified:  true,
+			Internal:  true,
+			Email:     fmt.Sprintf("%s@kiteworks.local", name),
+			Name:      strings.ToUpper(name[:1]) + name[1:],
+			BaseDirID: id * 100,
+			MyDirID:   id*100 + 1,
+		})
+		f.Folders = append(f.Folders,
+			mock_folder{KiteFolder: KiteFolder{ID: id*100 + 1, Name: "My Folder", ParentID: id * 100, UserID: id, Type: "d", Created: kw_date(-400), Expire: 0}},
+			mock_folder{KiteFolder: KiteFolder{ID: id*100 + 2, Name: "Finance", ParentID: id * 100, UserID: id, Type: "d", Created: kw_date(-200), Expire: kw_date(30), FileLifetime: 14}},
+			mock_folder{KiteFolder: KiteFolder{ID: id*100 + 3, Name: "Reports", ParentID: id*100 + 2, UserID: id, Type: "d", Created: kw_date(-100), Expire: 0}},
+			mock_folder{KiteFolder: KiteFolder{ID: id*100 + 4, Name: "Projects", ParentID: id * 100, UserID: id, Type: "d", Created: kw_date(-50), Expire: 0}},
+		)
+		f.Files = append(f.Files,
+			KiteFile{ID: id*1000 + 1, Name: "budget.xlsx", ParentID: id*100 + 2, UserID: id, Size: 48213, Created: kw_date(-20), Expire: 0},
+			KiteFile{ID: id*1000 + 2, Name: "q3-report.pdf", ParentID: id*100 + 3, UserID: id, Size: 1048576, Created: kw_date(-10), Expire: 0},
+			KiteFile{ID: id*1000 + 3, Name: "attachment.zip", ParentID: id*100 + 1, UserID: id, Size: 5242880, Created: kw_date(-90), Deleted: true},
+		)
+		f.Mail = append(f.Mail,
+			mock_mail{ID: id*10 + 1, UserID: id, Bucket: "draft", Date: kw_date(-120)},
+			mock_mail{ID: id*10 + 2, UserID: id, Bucket: "draft", Date: kw_date(-2)},
+		)
+	}
+
+	// Share alice's Finance folder with bob as a manager.
+	f.Folders[5].Members = []mock_member{{UserID: 3, RoleID: 4}}
+
+	return
+}
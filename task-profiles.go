@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func init() {
+	global.menu.RegisterCommand("profiles", "Manage API settings profiles: profiles list, copy <from> <to>, rename <from> <to>, delete <name>, default <name>.", profiles_task)
+}
+
+// Manages profiles.
+func profiles_task(flag *task) (err error) {
+	if err := flag.Parse(); err != nil {
+		return err
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	need := func(n int) error {
+		if len(args)-1 != n {
+			return fmt.Errorf("'profiles %s' expects %d profile names.", args[0], n)
+		}
+		for _, name := range args[1:] {
+			if err := valid_profile_name(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "list":
+		show_profiles()
+		return nil
+	case "copy":
+		if err := need(2); err != nil {
+			return err
+		}
+		return copy_profile(args[1], args[2], false)
+	case "rename":
+		if err := need(2); err != nil {
+			return err
+		}
+		return copy_profile(args[1], args[2], true)
+	case "delete":
+		if err := need(1); err != nil {
+			return err
+		}
+		return delete_profile(args[1])
+	case "default":
+		if err := need(1); err != nil {
+			return err
+		}
+		if !profile_exists(args[1]) {
+			return fmt.Errorf("No profile named '%s'.", args[1])
+		}
+		global.db.Set(APPNAME, "default_profile", args[1])
+		Log("Profile '%s' is now the default.", args[1])
+		return nil
+	default:
+		return fmt.Errorf("Unknown profiles command '%s', please specify list, copy, rename, delete or default.", args[0])
+	}
+}
+
+// Lists profiles, marking the default.
+func show_profiles() {
+	names := list_profiles()
+	if len(names) == 0 {
+		Log("No profiles have been configured, run %s --setup --profile <name> to create one.", APPNAME)
+		return
+	}
+	def := get_default_profile()
+	for _, name := range names {
+		var cfg Config
+		global.db.Get(profiles_table, name, &cfg)
+		mark := " "
+		if name == def {
+			mark = "*"
+		}
+		server := cfg.Server
+		if server == NONE {
+			server = "(unconfigured)"
+		}
+		Stdout("%s %-16s %s %s", mark, name, server, cfg.Admin)
+	}
+	Stdout("\n* default profile")
+}
+
+// Copies profile from to profile to, removing from when renaming.
+func copy_profile(from, to string, rename bool) error {
+	var cfg Config
+	if !global.db.Get(profiles_table, from, &cfg) {
+		return fmt.Errorf("No profile named '%s'.", from)
+	}
+	if profile_exists(to) {
+		return fmt.Errorf("Profile '%s' already exists.", to)
+	}
+	global.db.CryptSet(profiles_table, to, &cfg)
+
+	if !rename {
+		Log("Profile '%s' copied to '%s'.", from, to)
+		return nil
+	}
+
+	global.db.Unset(profiles_table, from)
+	clear_profile_tokens(from)
+	if get_default_profile() == from {
+		global.db.Set(APPNAME, "default_profile", to)
+	}
+	rename_profile_records(from, to)
+	Log("Profile '%s' renamed to '%s'.", from, to)
+	return nil
+}
+
+// Moves the run records and schedule entries of profile from over to profile to.
+func rename_profile_records(from, to string) {
+	for _, k := range global.db.ListKeys(runs_table) {
+		var r run_record
+		if global.db.Get(runs_table, k, &r) && r.Profile == from {
+			r.Profile = to
+			global.db.Set(runs_table, k, &r)
+		}
+	}
+	for _, k := range global.db.ListKeys(schedule_table) {
+		var e schedule_entry
+		if global.db.Get(schedule_table, k, &e) && e.Profile == from {
+			e.Profile = to
+			global.db.Set(schedule_table, k, &e)
+		}
+	}
+}
+
+// Deletes profile and its saved tokens.
+func delete_profile(name string) error {
+	if !profile_exists(name) {
+		return fmt.Errorf("No profile named '%s'.", name)
+	}
+	global.db.Unset(profiles_table, name)
+	clear_profile_tokens(name)
+	if get_default_profile() == name {
+		global.db.Unset(APPNAME, "default_profile")
+		Warn("Deleted the default profile, use '%s profiles default <name>' to choose a new one.", APPNAME)
+	}
+	Log("Profile '%s' deleted.", name)
+	return nil
+}
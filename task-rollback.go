@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	global.menu.Register("rollback", "Restore folder settings changed by a previous run.", rollback_task)
+}
+
+// Restores the folder settings journaled by a previous run.
+func rollback_task(flag *task) (err error) {
+	run := flag.String("run", "<run id>", "Run to roll back, as shown at the end of each run.")
+	list := flag.Bool("list", false, "List previous runs.")
+	if err := flag.Parse(); err != nil {
+		return err
+	}
+
+	if *list {
+		show_runs()
+		return nil
+	}
+
+	if *run == NONE {
+		return fmt.Errorf("--run is required, use --list to see previous runs.")
+	}
+
+	record, found := get_run(*run)
+	if !found {
+		return fmt.Errorf("No run found with id '%s'.", *run)
+	}
+	if err := record.check_profile(); err != nil {
+		return err
+	}
+	if record.DryRun {
+		return fmt.Errorf("Run '%s' was a dry run, there is nothing to roll back.", *run)
+	}
+
+	entries := read_journal(record.ID)
+	if len(entries) == 0 {
+		return fmt.Errorf("Run '%s' has no recorded changes to roll back.", *run)
+	}
+
+	// Rollbacks journal nothing and can't be rolled back themselves, so they aren't recorded as runs.
+	flag.ShowStart()
+	Log("Rolling back %d changes of '%s' run %s. (started %s)", len(entries), record.Task, record.ID, record.Started)
+
+	var restored int
+
+	ctx := work_context()
+
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		S := KWSession(e.User)
+		event := Event{
+			User:     e.User,
+			FolderID: e.FolderID,
+			Path:     e.Path,
+		}
+
+		var err error
+
+		switch e.Kind {
+		case JOURNAL_EXPIRY:
+			event.Action = "rollback_expiry"
+			event.New = map[string]interface{}{"expire": e.Expire, "file_lifetime": e.FileLifetime}
+			var expiry interface{} = 0
+			expire_str := "never"
+			if e.Expire != NONE {
+				t, terr := read_kw_time(e.Expire)
+				if terr != nil {
+					event.Err(terr, "[%s]: %s [%d]: Unable to read recorded expiry: %s", e.User, e.Path, e.FolderID, terr.Error())
+					continue
+				}
+				expiry = t
+				expire_str = dateString(t)
+			}
+			event.Log("[%s]: %s [%d]: Restoring Folder Expiry: %s - File Expiry: %d days.", e.User, e.Path, e.FolderID, expire_str, e.FileLifetime)
+			err = S.SetFolderAndFileExpiry(e.FolderID, expiry, e.FileLifetime)
+			report_change(report_row{
+				User:            e.User,
+				FolderID:        e.FolderID,
+				FolderPath:      e.Path,
+				Action:          event.Action,
+				NewExpiry:       expire_str,
+				NewFileLifetime: e.FileLifetime,
+			}, err)
+		case JOURNAL_NOTIFICATIONS:
+			event.Action = "rollback_notifications"
+			event.New = map[string]bool{"file_added": e.FileAdded, "comment_added": e.CommentAdded}
+			event.Log("[%s]: %s [%d]: Restoring notification settings. (File Notifications: %v, Folder Notifications: %v)", e.User, e.Path, e.FolderID, e.FileAdded, e.CommentAdded)
+			// Each nested folder has its own journal entry, so restore only the folder itself.
+			err = S.SetNotifications(e.FolderID, false, e.FileAdded, e.CommentAdded)
+			report_change(report_row{
+				User:          e.User,
+				FolderID:      e.FolderID,
+				FolderPath:    e.Path,
+				Action:        event.Action,
+				Notifications: fmt.Sprintf("file_added=%v comment_added=%v", e.FileAdded, e.CommentAdded),
+			}, err)
+		default:
+			Warn("[%s]: %s [%d]: Unknown journal entry '%s', skipping.", e.User, e.Path, e.FolderID, e.Kind)
+			continue
+		}
+
+		if err != nil {
+			event.Err(err, "[%s]: %s [%d]: %s", e.User, e.Path, e.FolderID, err.Error())
+			continue
+		}
+		restored++
+	}
+
+	if restored == len(entries) && !global.dry_run {
+		record.Status = RUN_ROLLED_BACK
+		record.Ended = time.Now().UTC().Format(time.RFC3339)
+		global.db.Set(runs_table, record.ID, &record)
+	}
+
+	Log("\n")
+	Log("Rolled back %d of %d changes.", restored, len(entries))
+	return nil
+}
+
+// Lists previous runs, newest last.
+func show_runs() {
+	runs := list_runs()
+	if len(runs) == 0 {
+		Log("No runs have been recorded.")
+		return
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Started < runs[j].Started })
+	for _, r := range runs {
+		mode := NONE
+		if r.DryRun {
+			mode = " (dry-run)"
+		}
+		Log("%s  %-20s %-12s %s %s%s", r.ID, r.Task, r.Status, r.Started, strings.Join(r.Args, " "), mode)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	global.menu.RegisterCommand("run", "Run the steps of a yaml or json playbook in order: run <playbook.yml> [name=value ...].", run_playbook_task)
+}
+
+// Outcome of a playbook step.
+type step_result struct {
+	step     *playbook_step
+	status   string
+	errors   int64
+	duration time.Duration
+	run_id   string
+}
+
+// Runs a playbook.
+func run_playbook_task(flag *task) (err error) {
+	dry_run := flag.Bool("dry-run", false, "Preview changes of every step, no changes will be sent to kiteworks.")
+	users := flag.String("user", "<user@domain.com>", "Single out users for every step, overrides the users of the playbook.")
+	if err := flag.Parse(); err != nil {
+		return err
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		return fmt.Errorf("Please specify a playbook, ie.. %s run playbook.yml", APPNAME)
+	}
+
+	vars := make(map[string]string)
+	for _, a := range args[1:] {
+		i := strings.Index(a, "=")
+		if i < 1 {
+			return fmt.Errorf("Unexpected argument '%s', playbook variables are set with name=value.", a)
+		}
+		vars[a[:i]] = a[i+1:]
+	}
+
+	pb, err := load_playbook(args[0], vars)
+	if err != nil {
+		return err
+	}
+	if *users != NONE {
+		if err := pb.Users.set(*users); err != nil {
+			return err
+		}
+	}
+
+	// The playbook holds the API session for all of its steps.
+	setup(false)
+
+	global.playbook = true
+	defer func() { global.playbook = false }()
+
+	Log("--> %s playbook '%s' started with %d steps.", APPNAME, pb.Name, len(pb.Steps))
+	Log("\n")
+
+	var (
+		results []step_result
+		stopped int
+	)
+
+	for i, step := range pb.Steps {
+		if stopped > 0 || cancelled() {
+			results = append(results, step_result{step: step, status: RUN_SKIPPED})
+			continue
+		}
+
+		step_args := step.args
+
+		// Local commands have no preview, so they're left out of a dry run rather than run for real.
+		if *dry_run && !global.menu.NeedsAPI(step_args) {
+			Log("--> Step %d/%d: %s skipped, local commands are not run with --dry-run.", i+1, len(pb.Steps), step.Name)
+			Log("\n")
+			results = append(results, step_result{step: step, status: RUN_SKIPPED})
+			continue
+		}
+
+		if global.menu.NeedsAPI(step_args) {
+			users := step.Users
+			if len(users) == 0 {
+				users = pb.Users
+			}
+			if len(users) > 0 && !has_flag(step_args[1:], "user") {
+				step_args = append([]string{step_args[0], "--user", strings.Join(users, ",")}, step_args[1:]...)
+			}
+			if *dry_run && !has_flag(step_args[1:], "dry-run") {
+				step_args = append(step_args, "--dry-run")
+			}
+		}
+
+		Log("--> Step %d/%d: %s", i+1, len(pb.Steps), step.Name)
+
+		errors := global.errors.Get()
+		last := last_run_id()
+		started := time.Now()
+
+		err := global.menu.Select(step_args)
+
+		r := step_result{
+			step:     step,
+			status:   RUN_COMPLETED,
+			errors:   global.errors.Get() - errors,
+			duration: time.Since(started),
+		}
+		if id := last_run_id(); id != last {
+			r.run_id = id
+		}
+
+		switch {
+		case cancelled():
+			r.status = RUN_CANCELLED
+		case err != nil:
+			r.status = RUN_FAILED
+			if err != ErrTaskFailed {
+				Err("Step %d: %s", i+1, strings.TrimSpace(err.Error()))
+			}
+			continue_on_error := pb.ContinueOnError
+			if step.ContinueOnError != nil {
+				continue_on_error = *step.ContinueOnError
+			}
+			if !continue_on_error {
+				stopped = i + 1
+			}
+		}
+		results = append(results, r)
+
+		Log("Step %d/%d %s in %s with %d errors.", i+1, len(pb.Steps), r.status, r.duration.Round(time.Second).String(), r.errors)
+		Log("\n")
+	}
+
+	show_playbook_results(pb, results)
+
+	// Have the summary report the planned changes of all steps.
+	if *dry_run {
+		global.dry_run = true
+	}
+
+	if stopped > 0 {
+		Err("Playbook '%s' stopped after step %d failed, set continue_on_error to carry on past it.", pb.Name, stopped)
+		return ErrTaskFailed
+	}
+	return nil
+}
+
+// Logs the outcome of each step of the playbook.
+func show_playbook_results(pb *playbook, results []step_result) {
+	counts := make(map[string]int)
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "STEP\tSTATUS\tERRORS\tDURATION\tRUN ID\tCOMMAND\n")
+	for i, r := range results {
+		counts[r.status]++
+		duration, run_id := "-", "-"
+		if r.status != RUN_SKIPPED {
+			duration = r.duration.Round(time.Second).String()
+		}
+		if r.run_id != NONE {
+			run_id = r.run_id
+		}
+		fmt.Fprintf(w, "%d. %s\t%s\t%d\t%s\t%s\t%s\n", i+1, r.step.Name, r.status, r.errors, duration, run_id, r.step.command())
+	}
+	w.Flush()
+
+	Log("Playbook '%s': %d completed, %d failed, %d cancelled, %d skipped.", pb.Name, counts[RUN_COMPLETED], counts[RUN_FAILED], counts[RUN_CANCELLED], counts[RUN_SKIPPED])
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		Log(line)
+	}
+}
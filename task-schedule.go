@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+func init() {
+	global.menu.RegisterCommand("schedule", "Manage tasks run by the daemon: schedule list, add \"<cron>\" <task> [args], remove <id>, history [id].", schedule_task)
+}
+
+// Manages the schedule kept in kitetool.db.
+func schedule_task(flag *task) (err error) {
+	if err := flag.Parse(); err != nil {
+		return err
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "list":
+		show_schedule(saved_schedule())
+		return nil
+	case "add":
+		if len(args) < 3 {
+			return fmt.Errorf("Please specify a schedule and task, ie.. schedule add \"0 2 * * *\" mail-cleanup --days 30")
+		}
+		entry, err := add_schedule(args[1], args[2:])
+		if err != nil {
+			return err
+		}
+		Log("Added schedule entry %s for profile '%s': %s, next run at %s.", entry.ID, entry.Profile, entry.command(), entry.spec.next(time.Now()).Format(time.RFC1123))
+		return nil
+	case "remove", "delete":
+		if len(args) != 2 {
+			return fmt.Errorf("Please specify the id of the schedule entry to remove, see schedule list.")
+		}
+		if err := remove_schedule(args[1]); err != nil {
+			return err
+		}
+		Log("Removed schedule entry %s.", args[1])
+		return nil
+	case "history":
+		var id string
+		if len(args) > 1 {
+			id = args[1]
+		}
+		show_schedule_history(id)
+		return nil
+	default:
+		return fmt.Errorf("Unknown schedule command '%s', please specify list, add, remove or history.", args[0])
+	}
+}
+
+// Lists schedule entries with their next and last runs.
+func show_schedule(entries []*schedule_entry) {
+	if len(entries) == 0 {
+		Log("No tasks have been scheduled, add one with: %s schedule add \"<cron>\" <task> [args]", APPNAME)
+		return
+	}
+	now := time.Now()
+	for _, e := range entries {
+		last := "never run"
+		if e.LastRun != NONE {
+			last = fmt.Sprintf("last %s %s, %d errors", e.LastRun, e.Status, e.Errors)
+		}
+		Log("[%s] %-16s %-12s next %s, %s: %s", e.ID, e.Cron, e.Profile, e.spec.next(now).Format("2006-01-02 15:04"), last, e.command())
+	}
+}
+
+// Lists the outcome of scheduled runs.
+func show_schedule_history(id string) {
+	outcomes := schedule_history(id)
+	if len(outcomes) == 0 {
+		Log("No scheduled runs have been recorded.")
+		return
+	}
+	for _, o := range outcomes {
+		var detail string
+		if o.RunID != NONE {
+			detail = fmt.Sprintf(" (run %s)", o.RunID)
+		}
+		if o.Message != NONE {
+			detail = fmt.Sprintf("%s: %s", detail, o.Message)
+		}
+		Log("%s [%s] %-10s %3d errors %8s %s%s", o.Started, o.Entry, o.Status, o.Errors, o.Duration, strings.Join(o.Args, " "), detail)
+	}
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	global.menu.RegisterCommand("shell", "Interactive prompt that keeps one API session and its caches between commands.", shell_task)
+}
+
+// Most commands kept in the shell history.
+const MAX_HISTORY = 500
+
+// Built in shell commands and their descriptions.
+var shell_builtins = [][2]string{
+	{"as <user@domain.com>", "Act as user, 'as' alone returns to the admin account."},
+	{"whoami", "Show the acting user."},
+	{"users [text]", "List users, optionally only those containing text."},
+	{"user <user@domain.com|id>", "Show a user's details."},
+	{"folders [id|path]", "List the acting user's top folders, or the folders within a folder."},
+	{"folder <id|path>", "Show a folder's details."},
+	{"history", "Show command history, !n repeats command n and !! the last command."},
+	{"help", "Show this help."},
+	{"exit", "Leave the shell."},
+}
+
+// Interactive shell state.
+type kite_shell struct {
+	acting  KWSession
+	history []string
+}
+
+// Runs the interactive shell.
+func shell_task(flag *task) (err error) {
+	if err := flag.Parse(); err != nil {
+		return err
+	}
+
+	// The shell holds the API session for all the commands run from it.
+	setup(false)
+
+	sh := &kite_shell{acting: KWAdmin}
+
+	var read func() (string, error)
+
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		t := term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stderr}, NONE)
+		t.AutoCompleteCallback = sh.complete
+		read = func() (string, error) {
+			state, err := term.MakeRaw(int(os.Stdin.Fd()))
+			if err != nil {
+				return NONE, err
+			}
+			defer term.Restore(int(os.Stdin.Fd()), state)
+			t.SetPrompt(sh.prompt())
+			return t.ReadLine()
+		}
+	} else {
+		input := bufio.NewReader(os.Stdin)
+		read = func() (string, error) {
+			line, err := input.ReadString('\n')
+			if err == io.EOF && line != NONE {
+				err = nil
+			}
+			return strings.TrimSpace(line), err
+		}
+	}
+
+	Stderr("%s shell, profile '%s' on %s. Type 'help' for commands, 'exit' to leave.\n", APPNAME, global.profile, global.config.Server)
+
+	for {
+		line, err := read()
+		if err != nil {
+			if err == io.EOF {
+				Stderr("\n")
+				return nil
+			}
+			return err
+		}
+
+		line, ok := sh.recall(strings.TrimSpace(line))
+		if !ok || line == NONE {
+			continue
+		}
+		sh.remember(line)
+
+		args, err := shell_split(line)
+		if err != nil {
+			Stderr("[ERROR] %s\n", err.Error())
+			continue
+		}
+
+		if sh.exec(args) {
+			return nil
+		}
+	}
+}
+
+// Returns the prompt, showing the acting user when it isn't the admin.
+func (sh *kite_shell) prompt() string {
+	if sh.acting != KWAdmin {
+		return fmt.Sprintf("%s (%s)> ", APPNAME, sh.acting)
+	}
+	return fmt.Sprintf("%s> ", APPNAME)
+}
+
+// Adds line to the history.
+func (sh *kite_shell) remember(line string) {
+	sh.history = append(sh.history, line)
+	if len(sh.history) > MAX_HISTORY {
+		sh.history = sh.history[len(sh.history)-MAX_HISTORY:]
+	}
+}
+
+// Expands !! and !n from the history.
+func (sh *kite_shell) recall(line string) (string, bool) {
+	if !strings.HasPrefix(line, "!") {
+		return line, true
+	}
+	if len(sh.history) == 0 {
+		Stderr("[ERROR] History is empty.\n")
+		return NONE, false
+	}
+	if line == "!!" {
+		line = sh.history[len(sh.history)-1]
+	} else {
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 1 || n > len(sh.history) {
+			Stderr("[ERROR] No command %s in history.\n", line)
+			return NONE, false
+		}
+		line = sh.history[n-1]
+	}
+	Stderr("%s\n", line)
+	return line, true
+}
+
+// Completes the command or user name under the cursor on tab.
+func (sh *kite_shell) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' || pos != len(line) {
+		return NONE, 0, false
+	}
+
+	fields := strings.Fields(line)
+	var candidates []string
+	var word string
+
+	switch {
+	case len(fields) == 0 || (len(fields) == 1 && !strings.HasSuffix(line, " ")):
+		if len(fields) == 1 {
+			word = fields[0]
+		}
+		for _, v := range shell_builtins {
+			candidates = append(candidates, strings.Fields(v[0])[0])
+		}
+		candidates = append(candidates, global.menu.Names()...)
+	case fields[0] == "as" || fields[0] == "user":
+		if !strings.HasSuffix(line, " ") {
+			word = fields[len(fields)-1]
+		}
+		candidates = global.cache.ListKeys("kw_users")
+	default:
+		return NONE, 0, false
+	}
+
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) && c != "shell" {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 0 {
+		return NONE, 0, false
+	}
+
+	completion := shared_prefix(matches)
+	if len(matches) == 1 {
+		completion = completion + " "
+	} else if completion == word {
+		sort.Strings(matches)
+		Stderr("\r\n%s\r\n", strings.Join(matches, "  "))
+		return NONE, 0, false
+	}
+
+	line = line[:len(line)-len(word)] + completion
+	return line, len(line), true
+}
+
+// Returns the prefix shared by all of input.
+func shared_prefix(input []string) string {
+	prefix := input[0]
+	for _, v := range input[1:] {
+		for !strings.HasPrefix(v, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// Splits line into arguments, honoring single and double quotes.
+func shell_split(line string) (args []string, err error) {
+	var (
+		arg     strings.Builder
+		quote   rune
+		has_arg bool
+	)
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			has_arg = true
+		case r == ' ' || r == '\t':
+			if has_arg {
+				args = append(args, arg.String())
+				arg.Reset()
+				has_arg = false
+			}
+		default:
+			arg.WriteRune(r)
+			has_arg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("Unterminated quote in command.")
+	}
+	if has_arg {
+		args = append(args, arg.String())
+	}
+	return
+}
+
+// Joins args into a command line, quoting those shell_split would otherwise break up.
+func join_args(args []string) string {
+	var output []string
+	for _, a := range args {
+		if a == NONE || strings.ContainsAny(a, " \t\"") {
+			a = fmt.Sprintf("'%s'", a)
+		} else if strings.Contains(a, "'") {
+			a = fmt.Sprintf("\"%s\"", a)
+		}
+		output = append(output, a)
+	}
+	return strings.Join(output, " ")
+}
+
+// Runs a shell command, returns true when the shell should exit.
+func (sh *kite_shell) exec(args []string) (exit bool) {
+	var err error
+
+	// Start each command with fresh contexts, so an interrupted command doesn't cancel the next.
+	reset_cancel()
+
+	switch strings.ToLower(args[0]) {
+	case "exit", "quit":
+		return true
+	case "help", "?":
+		sh.help()
+	case "history":
+		for i, v := range sh.history {
+			Stderr("%5d  %s\n", i+1, v)
+		}
+	case "whoami":
+		Stderr("%s\n", sh.acting)
+	case "as":
+		err = sh.act_as(args[1:])
+	case "users":
+		err = sh.show_users(args[1:])
+	case "user":
+		err = sh.show_user(args[1:])
+	case "folders":
+		err = sh.show_folders(args[1:])
+	case "folder":
+		err = sh.show_folder(args[1:])
+	case "shell":
+		err = fmt.Errorf("Already in the shell.")
+	default:
+		sh.run_task(args)
+	}
+
+	if err != nil {
+		Stderr("[ERROR] %s\n", err.Error())
+	}
+	return false
+}
+
+// Shows shell help.
+func (sh *kite_shell) help() {
+	Stderr("\nShell commands:\n")
+	for _, v := range shell_builtins {
+		Stderr("  %-34s%s\n", v[0], v[1])
+	}
+	global.menu.Show()
+}
+
+// Runs a menu task, scoped to the acting user when acting as one.
+func (sh *kite_shell) run_task(args []string) {
+	if sh.acting != KWAdmin && global.menu.NeedsAPI(args) && !has_flag(args[1:], "user") {
+		args = append([]string{args[0], "--user", string(sh.acting)}, args[1:]...)
+	}
+
+	err := global.menu.Select(args)
+	switch err {
+	case nil:
+		show_summary()
+	case ErrTaskFailed:
+	default:
+		Stderr("%s", err.Error())
+	}
+}
+
+// Switches the acting user.
+func (sh *kite_shell) act_as(args []string) error {
+	if len(args) == 0 {
+		sh.acting = KWAdmin
+		return nil
+	}
+	user, err := KWAdmin.KWUser(args[0])
+	if err != nil {
+		return fmt.Errorf("Unable to find user '%s': %s", args[0], err.Error())
+	}
+	sh.acting = KWSession(user.Email)
+	return nil
+}
+
+// Returns a user's status for display.
+func user_status(user KiteUser) string {
+	switch {
+	case user.Deleted:
+		return "deleted"
+	case user.Suspended:
+		return "suspended"
+	case user.Deactivated:
+		return "deactivated"
+	case !user.Active:
+		return "inactive"
+	case !user.Verified:
+		return "unverified"
+	}
+	return "active"
+}
+
+// Lists users containing the filter text.
+func (sh *kite_shell) show_users(args []string) error {
+	filter := strings.ToLower(strings.Join(args, " "))
+	users := KWAdmin.UserPager(PAGE_SIZE)
+
+	var count int
+	for {
+		if err := work_context().Err(); err != nil {
+			return err
+		}
+		var page []KiteUser
+		more, err := users.Next(&page)
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+		for _, u := range page {
+			if filter != NONE && !strings.Contains(strings.ToLower(u.Email+" "+u.Name), filter) {
+				continue
+			}
+			SetUserCache(&u)
+			Stderr("  %-8d %-40s %-30s %s\n", u.ID, u.Email, u.Name, user_status(u))
+			count++
+		}
+	}
+	Stderr("%d users.\n", count)
+	return nil
+}
+
+// Shows a user's details.
+func (sh *kite_shell) show_user(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Please specify a user's email or id.")
+	}
+	var input interface{} = args[0]
+	if id, err := strconv.Atoi(args[0]); err == nil {
+		input = id
+	}
+	user, err := KWAdmin.KWUser(input)
+	if err != nil {
+		return err
+	}
+	Stderr("  ID:         %d\n  Email:      %s\n  Name:       %s\n  Status:     %s\n  Internal:   %v\n  User Type:  %d\n  My Folder:  %d\n  Base Dir:   %d\n",
+		user.ID, user.Email, user.Name, user_status(*user), user.Internal, user.UserTypeID, user.MyDirID, user.BaseDirID)
+	return nil
+}
+
+// Returns the folder args refers to, by id or path.
+func (sh *kite_shell) find_folder(args []string) (KiteFolder, error) {
+	target := strings.Join(args, " ")
+	if id, err := strconv.Atoi(target); err == nil {
+		return sh.acting.FolderInfo(id)
+	}
+	return sh.acting.FindFolder(target)
+}
+
+// Lists the acting user's top folders, or the folders within a folder.
+func (sh *kite_shell) show_folders(args []string) error {
+	var (
+		folders []KiteFolder
+		err     error
+	)
+	if len(args) == 0 {
+		folders, err = sh.acting.GetFolders()
+	} else {
+		var parent KiteFolder
+		if parent, err = sh.find_folder(args); err != nil {
+			return err
+		}
+		folders, err = sh.acting.ListFolders(parent.ID)
+	}
+	if err != nil {
+		return err
+	}
+	for _, f := range folders {
+		Stderr("  %-8d %s\n", f.ID, f.Name)
+	}
+	Stderr("%d folders.\n", len(folders))
+	return nil
+}
+
+// Shows a folder's details.
+func (sh *kite_shell) show_folder(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Please specify a folder id or path.")
+	}
+	f, err := sh.find_folder(args)
+	if err != nil {
+		return err
+	}
+	expire := "never"
+	if e, ok := f.Expire.(string); ok {
+		if t, err := read_kw_time(e); err == nil {
+			expire = dateString(t)
+		}
+	}
+	Stderr("  ID:            %d\n  Name:          %s\n  Parent:        %d\n  Owner:         %d\n  Expires:       %s\n  File Lifetime: %d days\n  Role:          %s\n",
+		f.ID, f.Name, f.ParentID, f.UserID, expire, f.FileLifetime, f.CurrentUserRole.Name)
+	return nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/cmcoffee/go-nfo"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Prefix accepted on pins, as written by curl's --pinnedpubkey.
+const pin_prefix = "sha256//"
+
+// Returns the SHA-256 pin of cert's public key, base64 encoded.
+func spki_pin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Parses the comma separated pin list, pins may be base64 or hex encoded SHA-256 hashes.
+func parse_pins(pins string) (output [][]byte, err error) {
+	for _, pin := range strings.Split(pins, ",") {
+		pin = strings.TrimPrefix(strings.TrimSpace(pin), pin_prefix)
+		if pin == NONE {
+			continue
+		}
+		sum, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(sum) != sha256.Size {
+			sum, err = hex.DecodeString(strings.Replace(pin, ":", NONE, -1))
+		}
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("Invalid certificate pin '%s', pins should be base64 or hex encoded SHA-256 hashes of the public key.", pin)
+		}
+		output = append(output, sum)
+	}
+	return
+}
+
+// Builds the TLS configuration from the CA bundle, client certificate and pinning settings.
+func (c Config) tls_config() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: !c.SSLVerify}
+
+	if c.CABundle != NONE {
+		pem, err := ioutil.ReadFile(c.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read CA bundle: %s", err.Error())
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No PEM certificates found in CA bundle %s.", c.CABundle)
+		}
+		config.RootCAs = pool
+	}
+
+	if c.ClientCert != NONE || c.ClientKey != NONE {
+		if c.ClientCert == NONE || c.ClientKey == NONE {
+			return nil, fmt.Errorf("Both a client certificate and key are required for client certificate authentication.")
+		}
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load client certificate: %s", err.Error())
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	pins, err := parse_pins(c.PinnedKeys)
+	if err != nil {
+		return nil, err
+	}
+	if len(pins) > 0 {
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			for _, cert := range state.PeerCertificates {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				for _, pin := range pins {
+					if string(sum[:]) == string(pin) {
+						return nil
+					}
+				}
+			}
+			if len(state.PeerCertificates) > 0 {
+				return fmt.Errorf("kiteworks certificate does not match pinned keys, presented key is %s%s.", pin_prefix, spki_pin(state.PeerCertificates[0]))
+			}
+			return fmt.Errorf("kiteworks certificate does not match pinned keys.")
+		}
+	}
+
+	return config, nil
+}
+
+// Returns the certificate chain presented by kiteworks, without verifying it.
+func appliance_certs(cfg Config) ([]*x509.Certificate, error) {
+	if cfg.Server == NONE {
+		return nil, fmt.Errorf("kiteworks host must be configured to retrieve its certificate.")
+	}
+	if cfg.PlainHTTP {
+		return nil, fmt.Errorf("Certificates are not available over plain HTTP.")
+	}
+
+	cfg.SSLVerify = false
+	cfg.PinnedKeys = NONE
+
+	transport, err := new_transport(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   time.Second * 30,
+	}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Head(cfg.api_url("/"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("kiteworks did not present a certificate.")
+	}
+	return resp.TLS.PeerCertificates, nil
+}
+
+// Shows TLS settings for display.
+func (c Config) show_tls() string {
+	var opts []string
+	if c.SSLVerify {
+		opts = append(opts, "verify")
+	} else {
+		opts = append(opts, "NO VERIFY")
+	}
+	if c.CABundle != NONE {
+		opts = append(opts, "custom CA")
+	}
+	if c.ClientCert != NONE {
+		opts = append(opts, "client cert")
+	}
+	if pins, _ := parse_pins(c.PinnedKeys); len(pins) > 0 {
+		opts = append(opts, fmt.Sprintf("%d pinned keys", len(pins)))
+	}
+	return strings.Join(opts, ", ")
+}
+
+// Configuration for TLS settings.
+func (c *Config) setup_tls() {
+	// Validates changes, reverting them when invalid.
+	apply := func(prior Config) {
+		if _, err := c.tls_config(); err != nil {
+			Printf("\n*** %s\n", err.Error())
+			*c = prior
+			return
+		}
+		c.Tested = false
+	}
+
+	for {
+		verify := "Disable certificate verification. (Not recommended!)"
+		if !c.SSLVerify {
+			verify = "Enable certificate verification."
+		}
+
+		in := nfo.NeedAnswer(fmt.Sprintf(`
+--- TLS Configuration, Current Setting: %s
+
+    [1] %s
+    [2] Set CA Bundle:           %s
+    [3] Set Client Certificate:  %s
+    [4] Set Pinned Keys:         %s
+    [5] Pin Current kiteworks Certificate.
+
+(selection or 'b' to go back): `, c.show_tls(), verify, show_setting(c.CABundle), show_setting(c.ClientCert), show_setting(c.PinnedKeys)), nfo.Input)
+
+		prior := *c
+
+		switch in {
+		case "1":
+			c.SSLVerify = !c.SSLVerify
+			apply(prior)
+		case "2":
+			c.CABundle = nfo.Input(`
+# PEM file of CA certificates trusted in addition to the system's, blank to use only the system's.
+--> CA Bundle: `)
+			apply(prior)
+		case "3":
+			c.ClientCert = nfo.Input(`
+# PEM client certificate for mutual TLS, blank to disable.
+--> Client Certificate: `)
+			if c.ClientCert == NONE {
+				c.ClientKey = NONE
+			} else {
+				c.ClientKey = nfo.NeedAnswer("--> Client Key: ", nfo.Input)
+			}
+			apply(prior)
+		case "4":
+			c.PinnedKeys = nfo.Input(`
+# Comma separated SHA-256 hashes of trusted public keys, base64 or hex encoded, blank to disable.
+--> Pinned Keys: `)
+			apply(prior)
+		case "5":
+			certs, err := appliance_certs(*c)
+			if err != nil {
+				Printf("\n*** Unable to retrieve certificate: %s\n", err.Error())
+				continue
+			}
+			cert := certs[0]
+			pin := pin_prefix + spki_pin(cert)
+			Printf("\nSubject: %s\nIssuer:  %s\nExpires: %s\nKey:     %s\n", cert.Subject, cert.Issuer, cert.NotAfter.Format(time.RFC1123), pin)
+			if nfo.Confirm("\nWould you like to pin this key?") {
+				if c.PinnedKeys == NONE {
+					c.PinnedKeys = pin
+				} else if !strings.Contains(c.PinnedKeys, spki_pin(cert)) {
+					c.PinnedKeys = fmt.Sprintf("%s, %s", c.PinnedKeys, pin)
+				}
+				apply(prior)
+			}
+		case "b":
+			return
+		default:
+			Printf("\n*** Invalid option '%s', please try again.\n", in)
+		}
+	}
+}
+
+// Shows a setting for display.
+func show_setting(value string) string {
+	if value == NONE {
+		return "(none)"
+	}
+	return value
+}
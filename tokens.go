@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/cmcoffee/go-nfo"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	token_table         = "tokens"
+	token_expiry_margin = time.Duration(5 * time.Minute)  // Tokens expiring within the margin are replaced.
+	token_refresh_ahead = time.Duration(10 * time.Minute) // Tokens in use are refreshed this far ahead of expiry.
+)
+
+// Token being minted for a user, other callers wait on it rather than minting their own.
+type token_flight struct {
+	done  chan struct{}
+	token *Auth
+	err   error
+}
+
+// Token manager, mints at most one token at a time per user and keeps tokens in use fresh.
+var tokens struct {
+	mutex    sync.Mutex
+	flights  map[string]*token_flight
+	minted   map[string]struct{}    // Users that were issued a token during this run.
+	used     map[string]time.Time   // Last time each user's token was handed out.
+	refresh  map[string]*time.Timer // Background refreshes.
+	stopping bool
+}
+
+// Key of user's token in the tokens table, tokens are kept apart for each profile.
+func token_key(user string) string {
+	return fmt.Sprintf("kw_token:%s:%s", global.profile, user)
+}
+
+// Returns a token for user, minting one if the cached token is missing or about to expire.
+// When stale is set, it is the token the server rejected and a new one is minted, unless another caller has already replaced it.
+func get_token(ctx context.Context, user string, stale string) (*Auth, error) {
+	for {
+		tokens.mutex.Lock()
+		if tokens.flights == nil {
+			tokens.flights = make(map[string]*token_flight)
+			tokens.minted = make(map[string]struct{})
+			tokens.used = make(map[string]time.Time)
+			tokens.refresh = make(map[string]*time.Timer)
+		}
+
+		// Wait on a token already being minted for user.
+		if f, ok := tokens.flights[user]; ok {
+			tokens.mutex.Unlock()
+			select {
+			case <-f.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if f.err != nil {
+				return nil, f.err
+			}
+			if stale == NONE || f.token.AccessToken != stale {
+				return f.token, nil
+			}
+			continue
+		}
+
+		var token *Auth
+		if global.db.Get(token_table, token_key(user), &token) && token != nil {
+			if token.Expires > time.Now().Add(token_expiry_margin).Unix() && (stale == NONE || token.AccessToken != stale) {
+				tokens.used[user] = time.Now()
+				tokens.mutex.Unlock()
+				return token, nil
+			}
+		}
+
+		f := &token_flight{done: make(chan struct{})}
+		tokens.flights[user] = f
+		tokens.mutex.Unlock()
+
+		f.token, f.err = NewToken(ctx, user)
+
+		tokens.mutex.Lock()
+		if f.err == nil {
+			tokens.used[user] = time.Now()
+			// Replayed tokens are recorded and redacted, so they're neither saved over the user's token nor refreshed.
+			if global.cassette == nil || !global.cassette.replay {
+				global.db.CryptSet(token_table, token_key(user), f.token)
+				tokens.minted[user] = struct{}{}
+				schedule_token_refresh(user, f.token)
+			}
+		}
+		delete(tokens.flights, user)
+		tokens.mutex.Unlock()
+		close(f.done)
+
+		return f.token, f.err
+	}
+}
+
+// Schedules a background refresh of token ahead of its expiry, tokens.mutex must be held.
+func schedule_token_refresh(user string, token *Auth) {
+	if tokens.stopping {
+		return
+	}
+	if t, ok := tokens.refresh[user]; ok {
+		t.Stop()
+	}
+	wait := time.Until(time.Unix(token.Expires, 0).Add(-token_refresh_ahead))
+	if wait <= 0 {
+		return
+	}
+	tokens.refresh[user] = time.AfterFunc(wait, func() {
+		tokens.mutex.Lock()
+		delete(tokens.refresh, user)
+		// Only refresh tokens that are still being used.
+		active := time.Since(tokens.used[user]) < token_refresh_ahead
+		tokens.mutex.Unlock()
+		if !active {
+			return
+		}
+		if _, err := get_token(request_context(), user, token.AccessToken); err != nil {
+			nfo.Debug("[%s]: Background token refresh failed: %s", user, err.Error())
+		}
+	})
+}
+
+// Stops background refreshes and clears the tokens of users minted during this run, the admin's token is kept for the next run.
+func release_tokens() {
+	tokens.mutex.Lock()
+	defer tokens.mutex.Unlock()
+
+	tokens.stopping = true
+	for user, t := range tokens.refresh {
+		t.Stop()
+		delete(tokens.refresh, user)
+	}
+
+	for user := range tokens.minted {
+		if strings.ToLower(user) == strings.ToLower(string(KWAdmin)) {
+			continue
+		}
+		global.db.Unset(token_table, token_key(user))
+		delete(tokens.minted, user)
+	}
+}
+
+// Retires user's token once their work is done, stopping its refresh and clearing it from the tokens table.
+func retire_token(user string) {
+	if strings.ToLower(user) == strings.ToLower(string(KWAdmin)) {
+		return
+	}
+
+	tokens.mutex.Lock()
+	defer tokens.mutex.Unlock()
+
+	if t, ok := tokens.refresh[user]; ok {
+		t.Stop()
+		delete(tokens.refresh, user)
+	}
+	delete(tokens.used, user)
+	if _, ok := tokens.minted[user]; ok {
+		global.db.Unset(token_table, token_key(user))
+		delete(tokens.minted, user)
+	}
+}
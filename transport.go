@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Idle connections kept open to the kiteworks appliance.
+const MAX_IDLE_CONNS = 64
+
+// Transport shared by all sessions, rebuilt only when the configuration changes.
+var shared_transport struct {
+	mutex     sync.Mutex
+	config    Config
+	transport *http.Transport
+}
+
+// Connection reuse statistics for the run summary.
+var conn_stats struct {
+	opened stats_record
+	reused stats_record
+}
+
+// Returns the shared transport for the current configuration.
+func get_transport() (*http.Transport, error) {
+	shared_transport.mutex.Lock()
+	defer shared_transport.mutex.Unlock()
+
+	if shared_transport.transport == nil || shared_transport.config != global.config {
+		transport, err := new_transport(global.config)
+		if err != nil {
+			return nil, err
+		}
+		if shared_transport.transport != nil {
+			shared_transport.transport.CloseIdleConnections()
+		}
+		shared_transport.config = global.config
+		shared_transport.transport = transport
+	}
+	return shared_transport.transport, nil
+}
+
+// Returns the round tripper for API calls, passing through the cassette when recording or replaying,
+// and the HAR recorder when exporting.
+func api_transport() (transport http.RoundTripper, err error) {
+	transport, err = get_transport()
+	if err != nil {
+		return nil, err
+	}
+	if global.cassette != nil {
+		transport = global.cassette.transport(transport)
+	}
+	if global.har != nil {
+		transport = global.har.transport(transport)
+	}
+	return
+}
+
+// Builds a pooled transport from configuration.
+func new_transport(cfg Config) (*http.Transport, error) {
+	transport := &http.Transport{
+		MaxIdleConns:          MAX_IDLE_CONNS,
+		MaxIdleConnsPerHost:   MAX_IDLE_CONNS,
+		IdleConnTimeout:       time.Second * 90,
+		TLSHandshakeTimeout:   time.Second * 10,
+		ExpectContinueTimeout: time.Second,
+		ForceAttemptHTTP2:     true,
+	}
+
+	tls_config, err := cfg.tls_config()
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tls_config
+
+	proxy, err := cfg.proxy()
+	if err != nil {
+		return nil, err
+	}
+	transport.Proxy = proxy
+
+	dialer := &net.Dialer{
+		Timeout:   time.Second * 10,
+		KeepAlive: time.Second * 30,
+	}
+	transport.DialContext = dialer.DialContext
+
+	// Send connections for the overridden host to the chosen address, the URL keeps the host for TLS and the Host header.
+	// Connections through a proxy are resolved by the proxy, where the override would be ignored.
+	if err := cfg.check_resolve(); err != nil {
+		return nil, err
+	}
+	resolve_host, resolve_ip, err := cfg.resolve()
+	if err != nil {
+		return nil, err
+	}
+	if resolve_host != NONE {
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if host, port, err := net.SplitHostPort(addr); err == nil && strings.EqualFold(host, resolve_host) {
+				addr = net.JoinHostPort(resolve_ip, port)
+			}
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+
+	return transport, nil
+}
+
+// Tracks whether requests ride on new or reused connections.
+func trace_conn(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				conn_stats.reused.Add(1)
+			} else {
+				conn_stats.opened.Add(1)
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// Logs connection reuse for the run summary.
+func show_conn_stats() {
+	opened := conn_stats.opened.Get()
+	reused := conn_stats.reused.Get()
+	if total := opened + reused; total > 0 {
+		Log("Connections: %d opened, %d reused. (%d%% reuse)", opened, reused, reused*100/total)
+	}
+}
@@ -161,3 +161,13 @@ func (s KWSession) GetUsers(limit, offset int) (output []KiteUser, err error) {
 	return OutputArray.Users, s.Call(req)
 
 }
+
+// Returns a pager over all users on the system.
+func (s KWSession) UserPager(limit int) *Pager {
+	req := APIRequest{
+		Method: "GET",
+		Path:   "/rest/admin/users",
+		Params: SetParams(Query{"allowsCollaboration": true}),
+	}
+	return s.NewPager(req, limit)
+}